@@ -7,7 +7,9 @@ import (
 type Bridger interface {
 	Invite(channelID, username string) error
 	Join(channelName string) (string, string, error)
-	List() (map[string]string, error)
+	CreateChannel(channelName string, private bool) (string, error)
+	CreateGroup(userIDs []string) (string, error)
+	List() ([]*ChannelInfo, error)
 	Part(channel string) error
 	SetTopic(channelID, text string) error
 	Topic(channelID string) string
@@ -23,12 +25,18 @@ type Bridger interface {
 	MsgChannel(channelID, text string) (string, error)
 	MsgChannelThread(channelID, parentID, text string) (string, error)
 
+	UploadUser(userID, filename string, data []byte, text string) (string, error)
+	UploadChannel(channelID, filename string, data []byte, text string) (string, error)
+
 	AddReaction(msgID, emoji string) error
 	RemoveReaction(msgID, emoji string) error
 
 	StatusUser(userID string) (string, error)
 	StatusUsers() (map[string]string, error)
 	SetStatus(status string) error
+	SetDND(until time.Duration) error
+	SetCustomStatus(emoji, text string, expires time.Duration) error
+	GetCustomStatus(userID string) string
 
 	Protocol() string
 
@@ -39,29 +47,64 @@ type Bridger interface {
 	UpdateLastViewed(channelID string)
 	UpdateLastViewedUser(userID string) error
 	GetChannelID(name, teamID string) string
+	GetCategory(channelID string) string
+	IsMuted(channelID string) bool
+	MuteChannel(channelID string, mute bool) error
+	IsArchived(channelID string) bool
+	ArchiveChannel(channelID string, archive bool) error
 
 	GetChannelUsers(channelID string) ([]*UserInfo, error)
+	GetChannelMemberCount(channelID string) (int, error)
 	GetUsers() []*UserInfo
 	GetUser(userID string) *UserInfo
 	GetMe() *UserInfo
 	GetUserByUsername(username string) *UserInfo
+	GetCachedUserByUsername(username string) *UserInfo
+	GetUserProfile(userID string) (*UserProfile, error)
 	SearchUsers(query string) ([]*UserInfo, error)
 
 	GetTeamName(teamID string) string
 
 	GetPostsSince(channelID string, since int64) interface{}
 	GetPosts(channelID string, limit int) interface{}
-	SearchPosts(search string) interface{}
+	GetPostThread(postID string) interface{}
+	SearchPosts(search, team string) interface{}
 	ModifyPost(msgID, text string) error
 	GetFileLinks(fileIDs []string) []string
+	SetMFAToken(token string) error
+	ExecuteCommand(channelID, command string) error
+	DoPostAction(postID, button, option string) error
+	FollowThread(channelID, rootID string, follow bool) error
+	GetThreads(unread bool) ([]*ThreadInfo, error)
+	MarkThreadRead(channelID, rootID string) error
 }
 
 type ChannelInfo struct {
-	Name    string
-	ID      string
-	TeamID  string
-	DM      bool
-	Private bool
+	Name     string
+	ID       string
+	TeamID   string
+	DM       bool
+	Private  bool
+	Muted    bool
+	Archived bool
+	Topic    string
+	Purpose  string
+	Category string // sidebar category, eg Favorites
+	Users    int    // number of members, 0 if unknown
+	CreateAt int64  // in milliseconds
+	TopicAt  int64  // last change of the topic (or other channel settings), in milliseconds
+}
+
+// ThreadInfo is a thread we follow.
+type ThreadInfo struct {
+	ID             string // the ID of the root post
+	ChannelID      string
+	Text           string // the root message
+	User           *UserInfo
+	ReplyCount     int64
+	UnreadReplies  int64
+	UnreadMentions int64
+	LastReplyAt    int64
 }
 
 type UserInfo struct {
@@ -81,6 +124,19 @@ type UserInfo struct {
 	MentionKeys []string
 }
 
+// UserProfile is the profile of a user, as shown in WHOIS.
+type UserProfile struct {
+	DisplayName  string
+	Position     string
+	Email        string // empty when it's hidden
+	Timezone     string // eg Europe/Brussels
+	LastActivity int64  // in milliseconds, 0 if unknown
+	Bot          bool
+	Guest        bool
+	Deactivated  bool
+	Teams        []string
+}
+
 type Credentials struct {
 	Login    string
 	Team     string
@@ -88,6 +144,10 @@ type Credentials struct {
 	Server   string
 	Token    string
 	MFAToken string
+	// FromConfig is set when the credentials come from the configuration
+	// file. Only those may be secret references, credentials sent by an IRC
+	// client (PASS or LOGIN) are always used as is.
+	FromConfig bool
 }
 
 type Event struct {
@@ -115,6 +175,18 @@ type ChannelDeleteEvent struct {
 	ChannelID string
 }
 
+// ChannelUpdateEvent is sent when a channel is renamed, converted to a private
+// channel or gets a new header or purpose.
+type ChannelUpdateEvent struct {
+	ChannelID string
+}
+
+// ChannelMuteEvent is sent when we (un)mute a channel.
+type ChannelMuteEvent struct {
+	ChannelID string
+	Muted     bool
+}
+
 type ChannelMessageEvent struct {
 	Text        string
 	ChannelID   string
@@ -125,6 +197,8 @@ type ChannelMessageEvent struct {
 	MessageID   string
 	Event       string
 	ParentID    string
+	Thread      bool  // show in the thread channel of ParentID
+	CreateAt    int64 // in milliseconds, 0 if unknown
 }
 
 type ChannelTopicEvent struct {
@@ -142,6 +216,7 @@ type DirectMessageEvent struct {
 	MessageID string
 	Event     string
 	ParentID  string
+	CreateAt  int64 // in milliseconds, 0 if unknown
 }
 
 type FileEvent struct {
@@ -152,6 +227,8 @@ type FileEvent struct {
 	Files       []*File
 	MessageID   string
 	ParentID    string
+	Thread      bool  // show in the thread channel of ParentID
+	CreateAt    int64 // in milliseconds, 0 if unknown
 }
 
 type ReactionAddEvent struct {
@@ -162,6 +239,7 @@ type ReactionAddEvent struct {
 	ChannelType string
 	ParentUser  *UserInfo
 	Message     string
+	CreateAt    int64 // in milliseconds, 0 if unknown
 }
 
 type ReactionRemoveEvent ReactionAddEvent
@@ -172,11 +250,19 @@ type UserUpdateEvent struct {
 
 type StatusChangeEvent struct {
 	UserID string
-	Status string
+	Status string // online, away, dnd or offline
+}
+
+// ThreadUpdateEvent is sent when a thread we follow gets new replies or is read.
+type ThreadUpdateEvent struct {
+	Thread *ThreadInfo
 }
 
 type LogoutEvent struct{}
 
+// MFARequiredEvent is sent when a reconnect needs a new MFA token.
+type MFARequiredEvent struct{}
+
 type File struct {
 	Name string
 }
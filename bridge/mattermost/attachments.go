@@ -0,0 +1,298 @@
+package mattermost
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// ircColors are the 16 standard IRC colors, used to show the color of attachments.
+var ircColors = [16][3]int64{
+	{255, 255, 255}, {0, 0, 0}, {0, 0, 127}, {0, 147, 0},
+	{255, 0, 0}, {127, 0, 0}, {156, 0, 156}, {252, 127, 0},
+	{255, 255, 0}, {0, 252, 0}, {0, 147, 147}, {0, 255, 255},
+	{0, 0, 252}, {255, 0, 255}, {127, 127, 127}, {210, 210, 210},
+}
+
+// namedColors are the named attachment (slack) colors.
+var namedColors = map[string]int{
+	"good":    3,
+	"warning": 7,
+	"danger":  4,
+}
+
+// ircColor returns the IRC color code closest to an attachment color
+// (good, warning, danger or #rrggbb), or -1 if it can't be parsed.
+func ircColor(color string) int {
+	if code, ok := namedColors[color]; ok {
+		return code
+	}
+
+	color = strings.TrimPrefix(color, "#")
+	if len(color) == 3 {
+		color = string([]byte{color[0], color[0], color[1], color[1], color[2], color[2]})
+	}
+
+	rgb, err := strconv.ParseInt(color, 16, 32)
+	if err != nil || len(color) != 6 {
+		return -1
+	}
+
+	r, g, b := rgb>>16&0xff, rgb>>8&0xff, rgb&0xff
+	best, bestDist := -1, int64(-1)
+
+	for code, c := range ircColors {
+		dist := (r-c[0])*(r-c[0]) + (g-c[1])*(g-c[1]) + (b-c[2])*(b-c[2])
+		if bestDist == -1 || dist < bestDist {
+			best, bestDist = code, dist
+		}
+	}
+
+	return best
+}
+
+// postActions returns the interactive buttons and menus of all attachments,
+// numbered in the order formatAttachments shows them.
+func postActions(attachments []*model.SlackAttachment) []*model.PostAction {
+	var actions []*model.PostAction
+
+	for _, attachment := range attachments {
+		for _, action := range attachment.Actions {
+			if action != nil && !action.Disabled {
+				actions = append(actions, action)
+			}
+		}
+	}
+
+	return actions
+}
+
+// addAttachments adds the attachments of post (eg slack attachments) below its
+// message.
+func (m *Mattermost) addAttachments(post *model.Post) {
+	for _, line := range formatAttachments(post.Attachments(), m.v.GetBool("mattermost.unicode")) {
+		post.Message = post.Message + "\n" + line
+	}
+}
+
+// addPostListAttachments adds the attachments of the posts in list, so
+// replayed and requested posts (scrollback, thread, search) show them like new
+// messages.
+func (m *Mattermost) addPostListAttachments(list *model.PostList) *model.PostList {
+	if list == nil {
+		return nil
+	}
+
+	for _, post := range list.Posts {
+		m.addAttachments(post)
+	}
+
+	return list
+}
+
+// formatAttachments renders message attachments (eg from integrations and
+// webhooks) as IRC lines, each prefixed with a (colored) bar.
+func formatAttachments(attachments []*model.SlackAttachment, unicode bool) []string {
+	var lines []string
+
+	bar := "|"
+	if unicode {
+		bar = "▌"
+	}
+
+	actionIdx := 0
+
+	for _, attachment := range attachments {
+		if attachment == nil {
+			continue
+		}
+
+		var attLines []string
+
+		add := func(text string) {
+			for _, line := range strings.Split(text, "\n") {
+				if strings.TrimSpace(line) != "" {
+					attLines = append(attLines, line)
+				}
+			}
+		}
+
+		add(attachment.Pretext)
+
+		if attachment.AuthorName != "" {
+			add(attachment.AuthorName + linkSuffix(attachment.AuthorLink))
+		}
+
+		if attachment.Title != "" {
+			add("\x02" + attachment.Title + "\x0f" + linkSuffix(attachment.TitleLink))
+		}
+
+		add(attachment.Text)
+
+		for _, field := range attachment.Fields {
+			if field == nil {
+				continue
+			}
+
+			value := strings.ReplaceAll(fmt.Sprint(field.Value), "\n", " ")
+
+			if field.Title == "" {
+				add(value)
+				continue
+			}
+
+			add(field.Title + ": " + value)
+		}
+
+		if attachment.ImageURL != "" {
+			add(attachment.ImageURL)
+		}
+
+		if attachment.Footer != "" {
+			add(attachment.Footer)
+		}
+
+		var actions []string
+
+		for _, action := range attachment.Actions {
+			if action == nil || action.Disabled {
+				continue
+			}
+
+			actionIdx++
+			actions = append(actions, fmt.Sprintf("[%d] %s", actionIdx, formatAction(action)))
+		}
+
+		if len(actions) > 0 {
+			add("actions: " + strings.Join(actions, " "))
+		}
+
+		// nothing structured to show, fall back to the plain text version
+		if len(attLines) == 0 {
+			add(attachment.Fallback)
+		}
+
+		prefix := bar + " "
+		if code := ircColor(attachment.Color); code >= 0 {
+			prefix = fmt.Sprintf("\x03%02d%s\x0f ", code, bar)
+		}
+
+		for _, line := range attLines {
+			lines = append(lines, prefix+line)
+		}
+	}
+
+	return lines
+}
+
+// formatAction shows a button, or a menu with its options.
+func formatAction(action *model.PostAction) string {
+	if action.Type != model.POST_ACTION_TYPE_SELECT {
+		return action.Name
+	}
+
+	if action.DataSource != "" {
+		return action.Name + " (" + action.DataSource + ")"
+	}
+
+	var options []string
+
+	for _, option := range action.Options {
+		if option != nil {
+			options = append(options, option.Text)
+		}
+	}
+
+	return action.Name + " (" + strings.Join(options, "|") + ")"
+}
+
+func linkSuffix(link string) string {
+	if link == "" {
+		return ""
+	}
+
+	return " (" + link + ")"
+}
+
+// findPostAction returns the action with number or name button and the value
+// of the selected option for menus.
+func findPostAction(actions []*model.PostAction, button, option string) (*model.PostAction, string, error) {
+	var action *model.PostAction
+
+	if idx, err := strconv.Atoi(button); err == nil && idx > 0 && idx <= len(actions) {
+		action = actions[idx-1]
+	} else {
+		for _, a := range actions {
+			if strings.EqualFold(a.Name, button) {
+				action = a
+				break
+			}
+		}
+	}
+
+	if action == nil {
+		return nil, "", errors.New("no such action " + button)
+	}
+
+	if action.Type != model.POST_ACTION_TYPE_SELECT {
+		return action, "", nil
+	}
+
+	if option == "" {
+		return nil, "", errors.New("action " + action.Name + " needs an option")
+	}
+
+	// users and channels menus take an ID as value
+	if action.DataSource != "" {
+		return action, option, nil
+	}
+
+	for _, o := range action.Options {
+		if o != nil && (strings.EqualFold(o.Text, option) || o.Value == option) {
+			return action, o.Value, nil
+		}
+	}
+
+	return nil, "", errors.New("no such option " + option + " for action " + action.Name)
+}
+
+// DoPostAction fires the interactive button or menu (by number or name) of
+// postID, menus need the option to select.
+func (m *Mattermost) DoPostAction(postID, button, option string) error {
+	post, resp := m.mc.Client.GetPost(postID, "")
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	action, selected, err := findPostAction(postActions(post.Attachments()), button, option)
+	if err != nil {
+		return err
+	}
+
+	switch action.DataSource {
+	case "users":
+		user, resp := m.mc.Client.GetUserByUsername(strings.TrimPrefix(selected, "@"), "")
+		if resp.Error != nil {
+			return resp.Error
+		}
+
+		selected = user.Id
+	case "channels":
+		channelID := m.GetChannelID(strings.TrimPrefix(selected, "#"), m.mc.GetTeamFromChannel(post.ChannelId))
+		if channelID == "" {
+			return errors.New("no such channel " + selected)
+		}
+
+		selected = channelID
+	}
+
+	_, resp = m.mc.Client.DoPostActionWithCookie(postID, action.Id, selected, action.Cookie)
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	return nil
+}
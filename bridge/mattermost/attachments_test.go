@@ -0,0 +1,127 @@
+package mattermost
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatAttachments(t *testing.T) {
+	tests := []struct {
+		Desc        string
+		Attachments []*model.SlackAttachment
+		Unicode     bool
+		Expected    []string
+	}{
+		{
+			Desc: "fallback only",
+			Attachments: []*model.SlackAttachment{
+				{Fallback: "build #12 passed"},
+			},
+			Expected: []string{"| build #12 passed"},
+		},
+		{
+			Desc: "missing fallback",
+			Attachments: []*model.SlackAttachment{
+				{},
+			},
+		},
+		{
+			Desc: "structured",
+			Attachments: []*model.SlackAttachment{
+				{
+					Fallback:  "ignored",
+					Color:     "danger",
+					Pretext:   "pipeline failed",
+					Title:     "build #12",
+					TitleLink: "https://ci.example.com/12",
+					Text:      "tests failed\n\nsee log",
+					Fields: []*model.SlackAttachmentField{
+						{Title: "Branch", Value: "master"},
+						{Title: "Duration", Value: 42},
+					},
+					Footer: "ci",
+				},
+			},
+			Unicode: true,
+			Expected: []string{
+				"\x0304▌\x0f pipeline failed",
+				"\x0304▌\x0f \x02build #12\x0f (https://ci.example.com/12)",
+				"\x0304▌\x0f tests failed",
+				"\x0304▌\x0f see log",
+				"\x0304▌\x0f Branch: master",
+				"\x0304▌\x0f Duration: 42",
+				"\x0304▌\x0f ci",
+			},
+		},
+		{
+			Desc: "actions numbered across attachments",
+			Attachments: []*model.SlackAttachment{
+				{
+					Text: "deploy?",
+					Actions: []*model.PostAction{
+						{Name: "Approve"},
+						{Name: "Hidden", Disabled: true},
+					},
+				},
+				{
+					Color: "#00ff00",
+					Actions: []*model.PostAction{
+						{Name: "Env", Type: model.POST_ACTION_TYPE_SELECT, Options: []*model.PostActionOptions{
+							{Text: "dev", Value: "d"}, {Text: "prod", Value: "p"},
+						}},
+					},
+				},
+			},
+			Expected: []string{
+				"| deploy?",
+				"| actions: [1] Approve",
+				"\x0309|\x0f actions: [2] Env (dev|prod)",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, formatAttachments(tc.Attachments, tc.Unicode), tc.Desc)
+	}
+}
+
+func TestFindPostAction(t *testing.T) {
+	actions := []*model.PostAction{
+		{Id: "a", Name: "Approve"},
+		{Id: "b", Name: "Env", Type: model.POST_ACTION_TYPE_SELECT, Options: []*model.PostActionOptions{
+			{Text: "dev", Value: "d"}, {Text: "prod", Value: "p"},
+		}},
+	}
+
+	tests := []struct {
+		Desc     string
+		Button   string
+		Option   string
+		ID       string
+		Selected string
+		IsGood   bool
+	}{
+		{Desc: "by number", Button: "1", ID: "a", IsGood: true},
+		{Desc: "by name", Button: "approve", ID: "a", IsGood: true},
+		{Desc: "menu option", Button: "2", Option: "Prod", ID: "b", Selected: "p", IsGood: true},
+		{Desc: "menu value", Button: "env", Option: "d", ID: "b", Selected: "d", IsGood: true},
+		{Desc: "menu without option", Button: "env"},
+		{Desc: "unknown option", Button: "env", Option: "test"},
+		{Desc: "out of range", Button: "3"},
+		{Desc: "unknown", Button: "reject"},
+	}
+
+	for _, tc := range tests {
+		action, selected, err := findPostAction(actions, tc.Button, tc.Option)
+		if !tc.IsGood {
+			assert.Error(t, err, tc.Desc)
+			continue
+		}
+
+		assert.NoError(t, err, tc.Desc)
+		assert.Equal(t, tc.ID, action.Id, tc.Desc)
+		assert.Equal(t, tc.Selected, selected, tc.Desc)
+	}
+}
@@ -0,0 +1,72 @@
+package mattermost
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/42wim/matterircd/pkg/emoji"
+	"github.com/mattermost/mattermost-server/v5/model"
+	logger "github.com/sirupsen/logrus"
+)
+
+// isEmoji returns true if name is a standard or custom emoji of the server.
+// The custom emoji are fetched on first use and cached.
+func (m *Mattermost) isEmoji(name string) bool {
+	if emoji.IsSystem(name) {
+		return true
+	}
+
+	m.emojiMutex.Lock()
+	defer m.emojiMutex.Unlock()
+
+	if m.customEmoji == nil {
+		names, err := m.mc.GetCustomEmojiNames()
+		if err != nil {
+			logger.Errorf("getting custom emoji failed: %s", err)
+			// let the server decide
+			return true
+		}
+
+		m.customEmoji = make(map[string]bool)
+
+		for _, n := range names {
+			m.customEmoji[n] = true
+		}
+	}
+
+	return m.customEmoji[name]
+}
+
+// checkEmoji returns the shortcode of a reaction, which may be an unicode emoji.
+func (m *Mattermost) checkEmoji(name string) (string, error) {
+	if n, ok := emoji.Name(name); ok {
+		return n, nil
+	}
+
+	name = strings.Trim(name, ":")
+	if !m.isEmoji(name) {
+		return "", fmt.Errorf("unknown emoji %s", name)
+	}
+
+	return name, nil
+}
+
+// handleWsActionEmojiAdded adds new custom emoji to our cache.
+func (m *Mattermost) handleWsActionEmojiAdded(rmsg *model.WebSocketEvent) {
+	data, ok := rmsg.Data["emoji"].(string)
+	if !ok {
+		return
+	}
+
+	e := model.EmojiFromJson(strings.NewReader(data))
+	if e == nil {
+		return
+	}
+
+	m.emojiMutex.Lock()
+	defer m.emojiMutex.Unlock()
+
+	if m.customEmoji != nil {
+		m.customEmoji[e.Name] = true
+	}
+}
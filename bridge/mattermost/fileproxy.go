@@ -0,0 +1,248 @@
+package mattermost
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterircd/pkg/matterclient"
+	"github.com/mattermost/mattermost-server/v5/model"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const defaultFileProxyTTL = 24 * time.Hour
+
+// maxProxyFiles is the maximum number of links kept, the oldest ones are
+// dropped first.
+const maxProxyFiles = 100000
+
+// fileProxy serves mattermost files using the session of the user that
+// received them, so the links work without a mattermost login.
+// Links look like <FileProxyURL>/<id>-<signature>[/<filename>] and expire after FileProxyTTL.
+type fileProxy struct {
+	sync.Mutex
+	key     []byte
+	baseURL string
+	ttl     time.Duration
+	files   map[string]*proxyFile
+	// order has the IDs of files, oldest first. All links have the same TTL
+	// so they also expire in this order.
+	order []string
+}
+
+type proxyFile struct {
+	mc      *matterclient.Client
+	fileID  string
+	expires time.Time
+}
+
+var (
+	proxy     *fileProxy
+	proxyOnce sync.Once
+)
+
+// getFileProxy returns the file proxy, starting it on first use.
+// Returns nil if the file proxy isn't enabled.
+func getFileProxy(v *viper.Viper) *fileProxy {
+	bind := v.GetString("mattermost.FileProxyBind")
+	if bind == "" {
+		return nil
+	}
+
+	proxyOnce.Do(func() {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			logger.Errorf("fileproxy: can't create key: %s", err)
+			return
+		}
+
+		p := &fileProxy{
+			key:     key,
+			baseURL: strings.TrimSuffix(v.GetString("mattermost.FileProxyURL"), "/"),
+			ttl:     defaultFileProxyTTL,
+			files:   make(map[string]*proxyFile),
+		}
+
+		if p.baseURL == "" {
+			p.baseURL = "http://" + bind
+		}
+
+		if ttl, err := time.ParseDuration(v.GetString("mattermost.FileProxyTTL")); err == nil {
+			p.ttl = ttl
+		}
+
+		// only use the proxy when we can listen, otherwise the links would
+		// point to nothing and we fall back to the mattermost links.
+		ln, err := net.Listen("tcp", bind)
+		if err != nil {
+			logger.Errorf("fileproxy: can not listen on %s: %s", bind, err)
+			return
+		}
+
+		srv := &http.Server{
+			Handler:      p,
+			ReadTimeout:  time.Minute,
+			WriteTimeout: 10 * time.Minute,
+		}
+
+		go func() {
+			logger.Infof("fileproxy: listening on %s", bind)
+
+			if err := srv.Serve(ln); err != nil {
+				logger.Errorf("fileproxy: stopped listening on %s: %s", bind, err)
+			}
+		}()
+
+		proxy = p
+	})
+
+	return proxy
+}
+
+func (p *fileProxy) sign(id string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(id))
+
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// link returns a proxy link for fileID, served using the session of mc.
+// name is only used to make the link readable and may be empty.
+func (p *fileProxy) link(mc *matterclient.Client, fileID, name string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	id := hex.EncodeToString(b)
+
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+
+	// cleanup expired links, and the oldest ones when we have too many
+	for len(p.order) > 0 {
+		f := p.files[p.order[0]]
+		if !now.After(f.expires) && len(p.files) < maxProxyFiles {
+			break
+		}
+
+		delete(p.files, p.order[0])
+		p.order = p.order[1:]
+	}
+
+	p.files[id] = &proxyFile{
+		mc:      mc,
+		fileID:  fileID,
+		expires: now.Add(p.ttl),
+	}
+	p.order = append(p.order, id)
+
+	link := fmt.Sprintf("%s/%s-%s", p.baseURL, id, p.sign(id))
+	if name != "" {
+		link += "/" + url.PathEscape(name)
+	}
+
+	return link
+}
+
+// forget removes the links of mc (eg on logout).
+func (p *fileProxy) forget(mc *matterclient.Client) {
+	p.Lock()
+	defer p.Unlock()
+
+	order := p.order[:0]
+
+	for _, id := range p.order {
+		if p.files[id].mc == mc {
+			delete(p.files, id)
+			continue
+		}
+
+		order = append(order, id)
+	}
+
+	p.order = order
+}
+
+func (p *fileProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// /<id>-<signature>[/<filename>]
+	sp := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+
+	idsig := strings.SplitN(sp[0], "-", 2)
+	if len(idsig) != 2 || !hmac.Equal([]byte(p.sign(idsig[0])), []byte(idsig[1])) {
+		http.NotFound(w, r)
+		return
+	}
+
+	p.Lock()
+	f, ok := p.files[idsig[0]]
+	p.Unlock()
+
+	if !ok || time.Now().After(f.expires) {
+		http.Error(w, "link expired", http.StatusGone)
+		return
+	}
+
+	info, resp := f.mc.Client.GetFileInfo(f.fileID)
+	if resp.Error != nil {
+		logger.Errorf("fileproxy: getting file info of %s failed: %s", f.fileID, resp.Error)
+		http.Error(w, "file not available", http.StatusBadGateway)
+		return
+	}
+
+	data, resp := f.mc.Client.GetFile(f.fileID)
+	if resp.Error != nil {
+		logger.Errorf("fileproxy: getting file %s failed: %s", f.fileID, resp.Error)
+		http.Error(w, "file not available", http.StatusBadGateway)
+		return
+	}
+
+	mimeType := info.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", info.Name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+
+	http.ServeContent(w, r, info.Name, time.Unix(0, info.CreateAt*int64(time.Millisecond)), bytes.NewReader(data))
+}
+
+// proxyFileLinks returns file proxy links for fileIDs, named after the files
+// in infos (eg the metadata of a post) when they're known.
+func (m *Mattermost) proxyFileLinks(p *fileProxy, fileIDs []string, infos []*model.FileInfo) []string {
+	names := make(map[string]string)
+
+	for _, info := range infos {
+		names[info.Id] = info.Name
+	}
+
+	var output []string
+
+	for _, fileID := range fileIDs {
+		if link := p.link(m.mc, fileID, names[fileID]); link != "" {
+			output = append(output, link)
+		}
+	}
+
+	return output
+}
@@ -0,0 +1,100 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterircd/pkg/matterclient"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProxy(t *testing.T) {
+	mm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/files/file1/info":
+			json.NewEncoder(w).Encode(&model.FileInfo{Id: "file1", Name: "crash.log", MimeType: "text/plain"})
+		case "/api/v4/files/file1":
+			w.Write([]byte("panic"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mm.Close()
+
+	mc := &matterclient.Client{Client: model.NewAPIv4Client(mm.URL)}
+
+	p := &fileProxy{
+		key:     []byte("0123456789abcdef0123456789abcdef"),
+		baseURL: "http://proxy.example.com",
+		ttl:     time.Hour,
+		files:   make(map[string]*proxyFile),
+	}
+
+	link := p.link(mc, "file1", "crash.log")
+	assert.True(t, strings.HasPrefix(link, "http://proxy.example.com/"))
+	assert.True(t, strings.HasSuffix(link, "/crash.log"))
+
+	path := strings.TrimPrefix(link, "http://proxy.example.com")
+	idsig := strings.Split(strings.TrimPrefix(path, "/"), "/")[0]
+	id := strings.Split(idsig, "-")[0]
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return w
+	}
+
+	w := get(path)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "panic", w.Body.String())
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+
+	// the filename is optional
+	assert.Equal(t, http.StatusOK, get("/"+idsig).Code)
+
+	assert.Equal(t, http.StatusNotFound, get("/"+id+"-0123456789abcdef01234567/crash.log").Code, "bad signature")
+	assert.Equal(t, http.StatusNotFound, get("/"+id+"/crash.log").Code, "no signature")
+
+	// a valid signature of an unknown link
+	assert.Equal(t, http.StatusGone, get("/abcd-"+p.sign("abcd")).Code)
+
+	p.files[id].expires = time.Now().Add(-time.Minute)
+	assert.Equal(t, http.StatusGone, get(path).Code, "expired")
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestFileProxyCleanup(t *testing.T) {
+	mine := &matterclient.Client{}
+	other := &matterclient.Client{}
+
+	p := &fileProxy{
+		key:   []byte("0123456789abcdef0123456789abcdef"),
+		ttl:   time.Hour,
+		files: make(map[string]*proxyFile),
+	}
+
+	p.link(mine, "file1", "")
+	p.link(other, "file2", "")
+	p.link(mine, "file3", "")
+	assert.Len(t, p.files, 3)
+
+	p.files[p.order[0]].expires = time.Now().Add(-time.Minute)
+
+	p.link(mine, "file4", "")
+	assert.Len(t, p.files, 3, "expired link removed")
+	assert.Len(t, p.order, 3)
+
+	p.forget(mine)
+	assert.Len(t, p.files, 1)
+	assert.Len(t, p.order, 1)
+	assert.Equal(t, "file2", p.files[p.order[0]].fileID)
+}
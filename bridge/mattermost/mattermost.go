@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/42wim/matterircd/bridge"
+	"github.com/42wim/matterircd/pkg/emoji"
 	"github.com/42wim/matterircd/pkg/matterclient"
+	"github.com/42wim/matterircd/pkg/secret"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mitchellh/mapstructure"
@@ -23,6 +26,12 @@ type Mattermost struct {
 	eventChan   chan *bridge.Event
 	v           *viper.Viper
 	connected   bool
+
+	threadsMutex sync.RWMutex
+	threads      map[string]bool // root post IDs of the threads we (un)followed, true if shown in thread channels
+
+	emojiMutex  sync.Mutex
+	customEmoji map[string]bool // names of the custom emoji, nil until loaded
 }
 
 func New(v *viper.Viper, cred bridge.Credentials, eventChan chan *bridge.Event, onWsConnect func()) (bridge.Bridger, *matterclient.Client, error) {
@@ -30,6 +39,7 @@ func New(v *viper.Viper, cred bridge.Credentials, eventChan chan *bridge.Event,
 		credentials: cred,
 		eventChan:   eventChan,
 		v:           v,
+		threads:     make(map[string]bool),
 	}
 
 	logger.SetFormatter(&logger.TextFormatter{FullTimestamp: true})
@@ -63,6 +73,32 @@ func New(v *viper.Viper, cred bridge.Credentials, eventChan chan *bridge.Event,
 }
 
 func (m *Mattermost) loginToMattermost(onWsConnect func()) (*matterclient.Client, error) {
+	mc := m.newClient(onWsConnect)
+
+	logger.Infof("login as %s (team: %s) on %s", m.credentials.Login, m.credentials.Team, m.credentials.Server)
+
+	err := mc.Login()
+	if err != nil {
+		logger.Error("login failed", err)
+		return nil, err
+	}
+
+	logger.Info("login succeeded")
+
+	m.mc = mc
+	m.mc.WsQuit = false
+
+	quitChan := make(chan struct{})
+	m.quitChan = append(m.quitChan, quitChan)
+
+	go m.handleWsMessage(quitChan)
+
+	return mc, nil
+}
+
+// newClient returns a matterclient for our credentials, configured but not
+// logged in.
+func (m *Mattermost) newClient(onWsConnect func()) *matterclient.Client {
 	mc := matterclient.New(m.credentials.Login, m.credentials.Pass, m.credentials.Team, m.credentials.Server, m.credentials.MFAToken)
 	if m.v.GetBool("mattermost.Insecure") {
 		mc.Credentials.NoTLS = true
@@ -71,6 +107,19 @@ func (m *Mattermost) loginToMattermost(onWsConnect func()) (*matterclient.Client
 	// do anti idle on town-square, every installation should have this channel
 	mc.AntiIdle = !m.v.GetBool("mattermost.DisableAutoView") || m.v.GetBool("mattermost.ForceAntiIdle")
 	mc.OnWsConnect = onWsConnect
+	// never resolve secret references sent by an IRC client, those would
+	// allow anyone connecting to read files or run commands.
+	if m.credentials.FromConfig {
+		mc.ResolveSecret = secret.New(m.v.GetStringSlice("mattermost.SecretSources")).Resolve
+	}
+
+	mc.SessionDir = m.v.GetString("mattermost.SessionSaveDir")
+	mc.OnMFARequired = func() {
+		m.eventChan <- &bridge.Event{
+			Type: "mfa_required",
+			Data: &bridge.MFARequiredEvent{},
+		}
+	}
 
 	if m.v.GetBool("debug") {
 		mc.SetLogLevel("debug")
@@ -84,25 +133,7 @@ func (m *Mattermost) loginToMattermost(onWsConnect func()) (*matterclient.Client
 		}
 	*/
 
-	logger.Infof("login as %s (team: %s) on %s", m.credentials.Login, m.credentials.Team, m.credentials.Server)
-
-	err := mc.Login()
-	if err != nil {
-		logger.Error("login failed", err)
-		return nil, err
-	}
-
-	logger.Info("login succeeded")
-
-	m.mc = mc
-	m.mc.WsQuit = false
-
-	quitChan := make(chan struct{})
-	m.quitChan = append(m.quitChan, quitChan)
-
-	go m.handleWsMessage(quitChan)
-
-	return mc, nil
+	return mc
 }
 
 func (m *Mattermost) handleWsMessage(quitChan chan struct{}) {
@@ -139,14 +170,36 @@ func (m *Mattermost) handleWsMessage(quitChan chan struct{}) {
 				m.handleWsActionUserAdded(message.Raw)
 			case model.WEBSOCKET_EVENT_CHANNEL_CREATED:
 				m.handleWsActionChannelCreated(message.Raw)
+			case model.WEBSOCKET_EVENT_GROUP_ADDED:
+				m.handleWsActionGroupAdded(message.Raw)
 			case model.WEBSOCKET_EVENT_CHANNEL_DELETED:
 				m.handleWsActionChannelDeleted(message.Raw)
+			case model.WEBSOCKET_EVENT_CHANNEL_RESTORED:
+				m.handleWsActionChannelRestored(message.Raw)
+			case model.WEBSOCKET_EVENT_CHANNEL_UPDATED, model.WEBSOCKET_EVENT_CHANNEL_CONVERTED:
+				m.handleWsActionChannelUpdated(message.Raw)
 			case model.WEBSOCKET_EVENT_USER_UPDATED:
 				m.handleWsActionUserUpdated(message.Raw)
 			case model.WEBSOCKET_EVENT_STATUS_CHANGE:
 				m.handleStatusChangeEvent(message.Raw)
 			case model.WEBSOCKET_EVENT_REACTION_ADDED, model.WEBSOCKET_EVENT_REACTION_REMOVED:
 				m.handleReactionEvent(message.Raw)
+			case model.WEBSOCKET_EVENT_EPHEMERAL_MESSAGE:
+				m.handleWsActionEphemeral(message.Raw)
+			case "thread_updated":
+				m.handleWsActionThreadUpdated(message.Raw)
+			case model.WEBSOCKET_EVENT_ADDED_TO_TEAM:
+				m.handleWsActionAddedToTeam(message.Raw)
+			case model.WEBSOCKET_EVENT_LEAVE_TEAM:
+				m.handleWsActionLeaveTeam(message.Raw)
+			case model.WEBSOCKET_EVENT_EMOJI_ADDED:
+				m.handleWsActionEmojiAdded(message.Raw)
+			case model.WEBSOCKET_EVENT_CHANNEL_MEMBER_UPDATED:
+				m.handleWsActionChannelMemberUpdated(message.Raw)
+			case "sidebar_category_created", "sidebar_category_updated", "sidebar_category_deleted":
+				go m.mc.UpdateCategories()
+			case model.WEBSOCKET_EVENT_PREFERENCES_CHANGED, model.WEBSOCKET_EVENT_PREFERENCES_DELETED:
+				m.handleWsActionPreferencesChanged(message.Raw)
 			}
 		}
 	}
@@ -175,17 +228,9 @@ func (m *Mattermost) Invite(channelID, username string) error {
 }
 
 func (m *Mattermost) Join(channelName string) (string, string, error) {
-	teamID := ""
-
-	sp := strings.Split(channelName, "/")
-	if len(sp) > 1 {
-		team, _ := m.mc.Client.GetTeamByName(sp[0], "")
-		if team == nil {
-			return "", "", fmt.Errorf("cannot join channel (+i)")
-		}
-
-		teamID = team.Id
-		channelName = sp[1]
+	teamID, channelName := m.splitTeamChannel(channelName)
+	if teamID == "" && strings.Contains(channelName, "/") {
+		return "", "", fmt.Errorf("cannot join channel (+i)")
 	}
 
 	if teamID == "" {
@@ -200,30 +245,109 @@ func (m *Mattermost) Join(channelName string) (string, string, error) {
 		return "", "", fmt.Errorf("cannot join channel (+i)")
 	}
 
-	topic := m.mc.GetChannelHeader(channelID)
+	return channelID, m.Topic(channelID), nil
+}
+
+// CreateChannel creates a public or private channel, channelName can be
+// prefixed with a team (eg myteam/mychannel). We're a member of the new channel.
+func (m *Mattermost) CreateChannel(channelName string, private bool) (string, error) {
+	teamID, name := m.splitTeamChannel(channelName)
+	if teamID == "" && strings.Contains(channelName, "/") {
+		return "", fmt.Errorf("you're not a member of team %s", strings.SplitN(channelName, "/", 2)[0])
+	}
+
+	if teamID == "" {
+		teamID = m.mc.Team.ID
+	}
+
+	channelName = name
+
+	channelType := model.CHANNEL_OPEN
+	if private {
+		channelType = model.CHANNEL_PRIVATE
+	}
+
+	channel, resp := m.mc.Client.CreateChannel(&model.Channel{
+		TeamId:      teamID,
+		Name:        strings.ToLower(channelName),
+		DisplayName: channelName,
+		Type:        channelType,
+	})
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+
+	logger.Debugf("created channel %s, id %s", channelName, channel.Id)
+
+	m.mc.UpdateChannels()
+
+	return channel.Id, nil
+}
+
+// CreateGroup creates a group message channel with userIDs, or returns the
+// existing one if there's already a group with exactly those users.
+func (m *Mattermost) CreateGroup(userIDs []string) (string, error) {
+	me := false
+
+	for _, userID := range userIDs {
+		if userID == m.mc.User.Id {
+			me = true
+		}
+	}
+
+	if !me {
+		userIDs = append(userIDs, m.mc.User.Id)
+	}
+
+	channel, resp := m.mc.Client.CreateGroupChannel(userIDs)
+	if resp.Error != nil {
+		return "", resp.Error
+	}
 
-	return channelID, topic, nil
+	m.mc.UpdateChannels()
+
+	return channel.Id, nil
 }
 
-func (m *Mattermost) List() (map[string]string, error) {
-	channelinfo := make(map[string]string)
+// List returns the channels of all our teams, the ones we're a member of and
+// the public ones we can join.
+func (m *Mattermost) List() ([]*bridge.ChannelInfo, error) {
+	var channels []*bridge.ChannelInfo
+
+	seen := make(map[string]bool)
 
 	for _, channel := range append(m.mc.GetChannels(), m.mc.GetMoreChannels()...) {
-		// FIXME: This needs to be broken up into multiple messages to fit <510 chars
-		if strings.Contains(channel.Name, "__") {
+		if seen[channel.Id] || channel.IsGroupOrDirect() {
 			continue
 		}
 
+		seen[channel.Id] = true
+
 		channelName := "#" + channel.Name
 		// prefix channels outside of our team with team name
-		if channel.TeamId != m.mc.Team.ID {
-			channelName = m.mc.GetTeamName(channel.TeamId) + "/" + channel.Name
+		if channel.TeamId != m.mc.Team.ID || m.v.GetBool("mattermost.PrefixMainTeam") {
+			channelName = "#" + m.mc.GetTeamName(channel.TeamId) + "/" + channel.Name
 		}
 
-		channelinfo[channelName] = strings.ReplaceAll(channel.Header, "\n", " | ")
+		users, _ := m.mc.CachedMemberCount(channel.Id)
+
+		channels = append(channels, &bridge.ChannelInfo{
+			Name:     channelName,
+			ID:       channel.Id,
+			TeamID:   channel.TeamId,
+			Private:  !channel.IsOpen(),
+			Muted:    m.mc.IsMuted(channel.Id),
+			Archived: channel.DeleteAt > 0,
+			Topic:    strings.ReplaceAll(channel.Header, "\n", " | "),
+			Purpose:  strings.ReplaceAll(channel.Purpose, "\n", " | "),
+			Category: m.mc.GetCategory(channel.Id),
+			CreateAt: channel.CreateAt,
+			TopicAt:  channel.UpdateAt,
+			Users:    int(users),
+		})
 	}
 
-	return channelinfo, nil
+	return channels, nil
 }
 
 func (m *Mattermost) Part(channelID string) error {
@@ -251,6 +375,10 @@ func (m *Mattermost) Logout() error {
 
 		m.mc.WsQuit = true
 
+		if p := getFileProxy(m.v); p != nil {
+			p.forget(m.mc)
+		}
+
 		for _, c := range m.quitChan {
 			c <- struct{}{}
 		}
@@ -292,6 +420,42 @@ func (m *Mattermost) MsgUserThread(userID, parentID, text string) (string, error
 		return "", resp.Error
 	}
 
+	m.markThreadRead(dchannel.Id, parentID)
+
+	return rp.Id, nil
+}
+
+func (m *Mattermost) UploadUser(userID, filename string, data []byte, text string) (string, error) {
+	dchannel, resp := m.mc.Client.CreateDirectChannel(m.mc.User.Id, userID)
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+
+	return m.UploadChannel(dchannel.Id, filename, data, text)
+}
+
+func (m *Mattermost) UploadChannel(channelID, filename string, data []byte, text string) (string, error) {
+	fileID, err := m.mc.UploadFile(data, channelID, filename)
+	if err != nil {
+		return "", err
+	}
+
+	props := make(map[string]interface{})
+	props["matterircd_"+m.mc.User.Id] = true
+
+	post := &model.Post{
+		ChannelId: channelID,
+		Message:   text,
+		FileIds:   model.StringArray{fileID},
+	}
+
+	post.SetProps(props)
+
+	rp, resp := m.mc.Client.CreatePost(post)
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+
 	return rp.Id, nil
 }
 
@@ -317,6 +481,10 @@ func (m *Mattermost) MsgChannelThread(channelID, parentID, text string) (string,
 		return "", resp.Error
 	}
 
+	// we're participating in this thread now
+	m.setFollowingThread(parentID, true)
+	m.markThreadRead(channelID, parentID)
+
 	return rp.Id, nil
 }
 
@@ -343,6 +511,12 @@ func (m *Mattermost) ModifyPost(msgID, text string) error {
 
 func (m *Mattermost) AddReaction(msgID, emoji string) error {
 	logger.Debugf("adding reaction %#v, %#v", msgID, emoji)
+
+	emoji, err := m.checkEmoji(emoji)
+	if err != nil {
+		return err
+	}
+
 	reaction := &model.Reaction{
 		UserId:    m.mc.User.Id,
 		PostId:    msgID,
@@ -361,6 +535,12 @@ func (m *Mattermost) AddReaction(msgID, emoji string) error {
 
 func (m *Mattermost) RemoveReaction(msgID, emoji string) error {
 	logger.Debugf("removing reaction %#v, %#v", msgID, emoji)
+
+	emoji, err := m.checkEmoji(emoji)
+	if err != nil {
+		return err
+	}
+
 	reaction := &model.Reaction{
 		UserId:    m.mc.User.Id,
 		PostId:    msgID,
@@ -378,7 +558,25 @@ func (m *Mattermost) RemoveReaction(msgID, emoji string) error {
 }
 
 func (m *Mattermost) Topic(channelID string) string {
-	return m.mc.GetChannelHeader(channelID)
+	if _, rootID, ok := bridge.ParseThreadChannelID(channelID); ok {
+		return m.threadTopic(rootID)
+	}
+
+	return m.channelTopic(m.mc.GetChannelHeader(channelID), m.mc.GetChannelPurpose(channelID))
+}
+
+// channelTopic returns the IRC topic of a channel: its header, followed by its
+// purpose with ShowPurpose.
+func (m *Mattermost) channelTopic(header, purpose string) string {
+	if !m.v.GetBool("mattermost.showpurpose") || purpose == "" {
+		return header
+	}
+
+	if header == "" {
+		return purpose
+	}
+
+	return header + " - " + purpose
 }
 
 func (m *Mattermost) SetTopic(channelID, text string) error {
@@ -428,6 +626,70 @@ func (m *Mattermost) SetStatus(status string) error {
 	return nil
 }
 
+// SetDND sets our status to do not disturb, which ends after until when it isn't zero.
+func (m *Mattermost) SetDND(until time.Duration) error {
+	return m.mc.SetStatus(model.STATUS_DND, until)
+}
+
+// SetCustomStatus sets our custom status, an empty emoji and text removes it.
+func (m *Mattermost) SetCustomStatus(emoji, text string, expires time.Duration) error {
+	if emoji == "" && text == "" {
+		return m.mc.RemoveCustomStatus()
+	}
+
+	return m.mc.SetCustomStatus(emoji, text, expires)
+}
+
+// GetCustomStatus returns the custom status of userID as text, eg
+// ":palm_tree: on vacation (until 2021-08-01 00:00)"
+func (m *Mattermost) GetCustomStatus(userID string) string {
+	cs := m.mc.GetCustomStatus(userID)
+	if cs == nil {
+		return ""
+	}
+
+	var status []string
+
+	if cs.Emoji != "" {
+		status = append(status, ":"+cs.Emoji+":")
+	}
+
+	if cs.Text != "" {
+		status = append(status, cs.Text)
+	}
+
+	if !cs.ExpiresAt.IsZero() {
+		status = append(status, "(until "+cs.ExpiresAt.Local().Format("2006-01-02 15:04")+")")
+	}
+
+	return strings.Join(status, " ")
+}
+
+// GetUserProfile returns the (cached) profile of userID.
+func (m *Mattermost) GetUserProfile(userID string) (*bridge.UserProfile, error) {
+	p, err := m.mc.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := p.User.GetDisplayName(model.SHOW_NICKNAME_FULLNAME)
+	if displayName == p.User.Username {
+		displayName = ""
+	}
+
+	return &bridge.UserProfile{
+		DisplayName:  displayName,
+		Position:     p.User.Position,
+		Email:        p.User.Email,
+		Timezone:     p.User.GetPreferredTimezone(),
+		LastActivity: p.LastActivityAt,
+		Bot:          p.User.IsBot,
+		Guest:        p.User.IsGuest(),
+		Deactivated:  p.User.DeleteAt > 0,
+		Teams:        p.Teams,
+	}, nil
+}
+
 func (m *Mattermost) Nick(name string) error {
 	return m.mc.UpdateUserNick(name)
 }
@@ -435,6 +697,10 @@ func (m *Mattermost) Nick(name string) error {
 func (m *Mattermost) GetChannelName(channelID string) string {
 	var name string
 
+	if parentID, rootID, ok := bridge.ParseThreadChannelID(channelID); ok {
+		return bridge.ThreadChannelName(m.GetChannelName(parentID), rootID)
+	}
+
 	channelName := m.mc.GetChannelName(channelID)
 
 	if channelName == "" {
@@ -468,6 +734,12 @@ func (m *Mattermost) GetChannelName(channelID string) string {
 	return name
 }
 
+func (m *Mattermost) GetChannelMemberCount(channelID string) (int, error) {
+	count, err := m.mc.GetMemberCount(channelID)
+
+	return int(count), err
+}
+
 func (m *Mattermost) GetChannelUsers(channelID string) ([]*bridge.UserInfo, error) {
 	var (
 		mmusers, mmusersPaged []*model.User
@@ -536,11 +808,13 @@ func (m *Mattermost) GetChannels() []*bridge.ChannelInfo {
 		}
 
 		channels = append(channels, &bridge.ChannelInfo{
-			Name:    mmchannel.Name,
-			ID:      mmchannel.Id,
-			TeamID:  mmchannel.TeamId,
-			DM:      mmchannel.IsGroupOrDirect(),
-			Private: !mmchannel.IsOpen(),
+			Name:     mmchannel.Name,
+			ID:       mmchannel.Id,
+			TeamID:   mmchannel.TeamId,
+			DM:       mmchannel.IsGroupOrDirect(),
+			Private:  !mmchannel.IsOpen(),
+			Muted:    m.mc.IsMuted(mmchannel.Id),
+			Category: m.mc.GetCategory(mmchannel.Id),
 		})
 
 		chanMap[mmchannel.Id] = true
@@ -550,6 +824,20 @@ func (m *Mattermost) GetChannels() []*bridge.ChannelInfo {
 }
 
 func (m *Mattermost) GetChannel(channelID string) (*bridge.ChannelInfo, error) {
+	// a thread channel has the same info as the channel of the thread
+	if parentID, rootID, ok := bridge.ParseThreadChannelID(channelID); ok {
+		parent, err := m.GetChannel(parentID)
+		if err != nil {
+			return nil, err
+		}
+
+		info := *parent
+		info.ID = channelID
+		info.Name = bridge.ThreadChannelName(parent.Name, rootID)
+
+		return &info, nil
+	}
+
 	for _, channel := range m.GetChannels() {
 		if channel.ID == channelID {
 			return channel, nil
@@ -588,6 +876,17 @@ func (m *Mattermost) GetUserByUsername(username string) *bridge.UserInfo {
 	}
 }
 
+// GetCachedUserByUsername returns the user with username if it's known, nil
+// otherwise. Unlike GetUserByUsername it never asks the server.
+func (m *Mattermost) GetCachedUserByUsername(username string) *bridge.UserInfo {
+	mmuser := m.mc.GetCachedUserByUsername(username)
+	if mmuser == nil {
+		return nil
+	}
+
+	return m.createUser(mmuser)
+}
+
 func (m *Mattermost) createUser(mmuser *model.User) *bridge.UserInfo {
 	teamID := ""
 
@@ -736,14 +1035,17 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 	}
 
 	replyMessage := ""
+	thread := false
+
 	if data.ParentId != "" {
 		parentPost, resp := m.mc.Client.GetPost(data.ParentId, "")
 		if resp.Error != nil {
 			logger.Errorf("Unable to get parent post for %#v", data)
 		} else {
 			parentGhost := m.GetUser(parentPost.UserId)
+			thread = m.showInThreadChannel(rmsg, data, parentPost)
 
-			if !m.v.GetBool("mattermost.hidereplies") {
+			if !m.v.GetBool("mattermost.hidereplies") && !thread {
 				parentMessage := maybeShorten(parentPost.Message, m.v.GetInt("mattermost.ShortenRepliesTo"), "@", m.v.GetBool("mattermost.unicode"))
 				replyMessage = fmt.Sprintf(" (re @%s: %s)", parentGhost.Nick, parentMessage)
 			}
@@ -763,13 +1065,10 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 		}
 	}
 
-	// if we got attachments (eg slack attachments) and we have a fallback message, show this.
-	if entries, ok := extraProps["attachments"].([]interface{}); ok {
-		for _, entry := range entries {
-			if f, ok := entry.(map[string]interface{}); ok {
-				data.Message = data.Message + "\n" + f["fallback"].(string)
-			}
-		}
+	m.addAttachments(data)
+
+	if m.v.GetBool("mattermost.unicode") {
+		data.Message = emoji.ToUnicode(data.Message)
 	}
 
 	// check if we have a override_username (from webhooks) and use it
@@ -794,12 +1093,27 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 		return
 	}
 
+	if data.Type == "system_purpose_change" && m.v.GetBool("mattermost.showpurpose") {
+		if purpose, ok := extraProps["new_purpose"].(string); ok {
+			m.eventChan <- &bridge.Event{
+				Type: "channel_topic",
+				Data: &bridge.ChannelTopicEvent{
+					Text:      m.channelTopic(m.mc.GetChannelHeader(data.ChannelId), purpose),
+					ChannelID: data.ChannelId,
+					UserID:    data.UserId,
+				},
+			}
+		}
+
+		return
+	}
+
 	if data.Type == "system_header_change" {
-		if topic, ok := extraProps["new_header"].(string); ok {
+		if header, ok := extraProps["new_header"].(string); ok {
 			event := &bridge.Event{
 				Type: "channel_topic",
 				Data: &bridge.ChannelTopicEvent{
-					Text:      topic,
+					Text:      m.channelTopic(header, m.mc.GetChannelPurpose(data.ChannelId)),
 					ChannelID: data.ChannelId,
 					UserID:    data.UserId,
 				},
@@ -868,6 +1182,7 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 				MessageID: data.Id,
 				Event:     rmsg.Event,
 				ParentID:  data.ParentId,
+				CreateAt:  data.CreateAt,
 			}
 
 			if ghost.Me {
@@ -909,6 +1224,8 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 					MessageID:   data.Id,
 					Event:       rmsg.Event,
 					ParentID:    data.ParentId,
+					Thread:      thread,
+					CreateAt:    data.CreateAt,
 				},
 			}
 
@@ -931,6 +1248,8 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 					MessageID:   data.Id,
 					Event:       rmsg.Event,
 					ParentID:    data.ParentId,
+					Thread:      thread,
+					CreateAt:    data.CreateAt,
 				},
 			}
 
@@ -942,16 +1261,102 @@ func (m *Mattermost) handleWsActionPost(rmsg *model.WebSocketEvent) {
 		}
 	}
 
-	m.handleFileEvent(channelType, ghost, data, rmsg)
+	m.handleFileEvent(channelType, ghost, data, rmsg, thread)
 
 	logger.Debugf("handleWsActionPost() user %s sent %s", m.mc.GetUser(data.UserId).Username, data.Message)
 	logger.Debugf("%#v", data)
 }
 
+// handleWsActionEphemeral shows ephemeral messages (eg slash command
+// responses) which are only visible to us.
+func (m *Mattermost) handleWsActionEphemeral(rmsg *model.WebSocketEvent) {
+	postData, ok := rmsg.Data["post"].(string)
+	if !ok {
+		return
+	}
+
+	data := model.PostFromJson(strings.NewReader(postData))
+	if data == nil || data.Message == "" {
+		return
+	}
+
+	// system messages come from the service bot, the same user as the one
+	// that's used for the service commands (/msg mattermost ...)
+	service := &bridge.UserInfo{
+		Nick:  m.Protocol(),
+		User:  m.Protocol(),
+		Host:  "service",
+		Ghost: true,
+	}
+
+	name := m.GetChannelName(data.ChannelId)
+
+	// direct message, show it in the query with the service bot as we don't
+	// know which user it's from.
+	if strings.Contains(name, "__") {
+		prefix := "(only visible to you) "
+		if dmuser := m.getDMUser(name); dmuser != nil {
+			prefix = "(only visible to you, in your conversation with " + dmuser.Nick + ") "
+		}
+
+		for _, msg := range strings.Split(data.Message, "\n") {
+			if msg == "" {
+				continue
+			}
+
+			m.eventChan <- &bridge.Event{
+				Type: "direct_message",
+				Data: &bridge.DirectMessageEvent{
+					Text:      prefix + msg,
+					ChannelID: data.ChannelId,
+					Sender:    service,
+					Receiver:  m.GetMe(),
+				},
+			}
+		}
+
+		return
+	}
+
+	sender := service
+
+	// the response of a bot, otherwise it's from the system
+	if ghost := m.GetUser(data.UserId); ghost.Nick != "" && data.UserId != m.GetMe().User {
+		sender = ghost
+	}
+
+	for _, msg := range strings.Split(data.Message, "\n") {
+		if msg == "" {
+			continue
+		}
+
+		m.eventChan <- &bridge.Event{
+			Type: "channel_message",
+			Data: &bridge.ChannelMessageEvent{
+				Text:        msg,
+				ChannelID:   data.ChannelId,
+				Sender:      sender,
+				MessageType: "notice",
+				Event:       rmsg.Event,
+			},
+		}
+	}
+}
+
 func (m *Mattermost) getFilesFromData(data *model.Post) []*bridge.File {
 	files := []*bridge.File{}
 
-	for _, fname := range m.mc.GetFileLinks(data.FileIds) {
+	var links []string
+
+	// use the file names of the post metadata, so the proxy doesn't need to
+	// look them up.
+	if p := getFileProxy(m.v); p != nil && data.Metadata != nil {
+		links = m.proxyFileLinks(p, data.FileIds, data.Metadata.Files)
+	} else {
+		links = m.GetFileLinks(data.FileIds)
+	}
+
+	for _, fname := range links {
 		files = append(files, &bridge.File{
 			Name: fname,
 		})
@@ -960,7 +1365,7 @@ func (m *Mattermost) getFilesFromData(data *model.Post) []*bridge.File {
 	return files
 }
 
-func (m *Mattermost) handleFileEvent(channelType string, ghost *bridge.UserInfo, data *model.Post, rmsg *model.WebSocketEvent) {
+func (m *Mattermost) handleFileEvent(channelType string, ghost *bridge.UserInfo, data *model.Post, rmsg *model.WebSocketEvent, thread bool) {
 	event := &bridge.Event{
 		Type: "file_event",
 	}
@@ -972,6 +1377,8 @@ func (m *Mattermost) handleFileEvent(channelType string, ghost *bridge.UserInfo,
 		ChannelID:   data.ChannelId,
 		MessageID:   data.Id,
 		ParentID:    data.ParentId,
+		Thread:      thread,
+		CreateAt:    data.CreateAt,
 	}
 
 	event.Data = fileEvent
@@ -1095,6 +1502,57 @@ func (m *Mattermost) handleWsActionUserRemoved(rmsg *model.WebSocketEvent) {
 	m.eventChan <- event
 }
 
+// handleWsActionPreferencesChanged reloads the sidebar categories when
+// favorites change, they're preferences on servers without sidebar categories.
+func (m *Mattermost) handleWsActionPreferencesChanged(rmsg *model.WebSocketEvent) {
+	data, ok := rmsg.Data["preferences"].(string)
+	if !ok {
+		return
+	}
+
+	prefs, err := model.PreferencesFromJson(strings.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	for _, pref := range prefs {
+		if pref.Category == model.PREFERENCE_CATEGORY_FAVORITE_CHANNEL {
+			go m.mc.UpdateCategories()
+			return
+		}
+	}
+}
+
+// handleWsActionChannelMemberUpdated keeps our notification preferences
+// current and sends an event when a channel gets (un)muted.
+func (m *Mattermost) handleWsActionChannelMemberUpdated(rmsg *model.WebSocketEvent) {
+	data, ok := rmsg.Data["channelMember"].(string)
+	if !ok {
+		return
+	}
+
+	member := model.ChannelMemberFromJson(strings.NewReader(data))
+	if member == nil || member.UserId != m.mc.User.Id {
+		return
+	}
+
+	muted := m.mc.IsMuted(member.ChannelId)
+
+	m.mc.SetNotifyProps(member.ChannelId, member.NotifyProps)
+
+	if muted == m.mc.IsMuted(member.ChannelId) {
+		return
+	}
+
+	m.eventChan <- &bridge.Event{
+		Type: "channel_mute",
+		Data: &bridge.ChannelMuteEvent{
+			ChannelID: member.ChannelId,
+			Muted:     !muted,
+		},
+	}
+}
+
 func (m *Mattermost) handleWsActionUserUpdated(rmsg *model.WebSocketEvent) {
 	var info model.User
 
@@ -1104,6 +1562,9 @@ func (m *Mattermost) handleWsActionUserUpdated(rmsg *model.WebSocketEvent) {
 		return
 	}
 
+	// keep the custom status up to date
+	m.mc.CacheUser(&info)
+
 	event := &bridge.Event{
 		Type: "user_updated",
 		Data: &bridge.UserUpdateEvent{
@@ -1130,20 +1591,172 @@ func (m *Mattermost) handleWsActionChannelCreated(rmsg *model.WebSocketEvent) {
 	m.eventChan <- event
 }
 
+// handleWsActionGroupAdded handles being added to a (new) group message channel.
+func (m *Mattermost) handleWsActionGroupAdded(rmsg *model.WebSocketEvent) {
+	if rmsg.Broadcast.ChannelId == "" {
+		return
+	}
+
+	event := &bridge.Event{
+		Type: "channel_create",
+		Data: &bridge.ChannelCreateEvent{
+			ChannelID: rmsg.Broadcast.ChannelId,
+		},
+	}
+
+	m.eventChan <- event
+}
+
 func (m *Mattermost) handleWsActionChannelDeleted(rmsg *model.WebSocketEvent) {
 	channelID, ok := rmsg.Data["channel_id"].(string)
 	if !ok {
 		return
 	}
 
-	event := &bridge.Event{
-		Type: "channel_delete",
-		Data: &bridge.ChannelDeleteEvent{
-			ChannelID: channelID,
+	deleteAt := model.GetMillis()
+	if at, ok := rmsg.Data["delete_at"].(float64); ok {
+		deleteAt = int64(at)
+	}
+
+	// the channel is archived now, it can still be read. Updating the channels
+	// takes a while on large servers, don't block the websocket meanwhile.
+	m.mc.SetChannelArchived(channelID, deleteAt)
+
+	go func() {
+		if err := m.mc.UpdateChannels(); err != nil {
+			logger.Errorf("updating channels failed: %s", err)
+		}
+
+		m.eventChan <- &bridge.Event{
+			Type: "channel_delete",
+			Data: &bridge.ChannelDeleteEvent{
+				ChannelID: channelID,
+			},
+		}
+	}()
+}
+
+// handleWsActionChannelUpdated updates a renamed or converted (public to
+// private) channel, or one with a new header or purpose.
+func (m *Mattermost) handleWsActionChannelUpdated(rmsg *model.WebSocketEvent) {
+	if data, ok := rmsg.Data["channel"].(string); ok {
+		if channel := model.ChannelFromJson(strings.NewReader(data)); channel != nil {
+			m.updateChannel(channel)
+		}
+
+		return
+	}
+
+	// channel_converted only has the ID, don't block the websocket on fetching
+	// the channel
+	if channelID, ok := rmsg.Data["channel_id"].(string); ok {
+		go func() {
+			channel, resp := m.mc.Client.GetChannel(channelID, "")
+			if resp.Error != nil {
+				logger.Errorf("getting channel %s failed: %s", channelID, resp.Error)
+				return
+			}
+
+			m.updateChannel(channel)
+		}()
+	}
+}
+
+// updateChannel caches the updated channel and tells the user about it.
+func (m *Mattermost) updateChannel(channel *model.Channel) {
+	m.mc.SetChannel(channel)
+
+	m.eventChan <- &bridge.Event{
+		Type: "channel_update",
+		Data: &bridge.ChannelUpdateEvent{
+			ChannelID: channel.Id,
 		},
 	}
+}
 
-	m.eventChan <- event
+// handleWsActionChannelRestored brings back an unarchived channel we're a member of.
+func (m *Mattermost) handleWsActionChannelRestored(rmsg *model.WebSocketEvent) {
+	channelID, ok := rmsg.Data["channel_id"].(string)
+	if !ok {
+		return
+	}
+
+	m.mc.SetChannelArchived(channelID, 0)
+
+	// see handleWsActionChannelDeleted
+	go func() {
+		if err := m.mc.UpdateChannels(); err != nil {
+			logger.Errorf("updating channels failed: %s", err)
+			return
+		}
+
+		for _, channel := range m.mc.GetChannels() {
+			if channel.Id != channelID {
+				continue
+			}
+
+			m.eventChan <- &bridge.Event{
+				Type: "channel_create",
+				Data: &bridge.ChannelCreateEvent{
+					ChannelID: channelID,
+				},
+			}
+
+			return
+		}
+	}()
+}
+
+// handleWsActionAddedToTeam loads a team we've joined and its channels.
+func (m *Mattermost) handleWsActionAddedToTeam(rmsg *model.WebSocketEvent) {
+	teamID, ok := rmsg.Data["team_id"].(string)
+	if !ok || rmsg.Data["user_id"] != m.mc.User.Id {
+		return
+	}
+
+	// loading the team pages through all its users, see
+	// handleWsActionChannelDeleted
+	go func() {
+		if err := m.mc.AddTeam(teamID); err != nil {
+			logger.Errorf("adding team %s failed: %s", teamID, err)
+			return
+		}
+
+		for _, channel := range m.mc.GetChannels() {
+			if channel.TeamId != teamID {
+				continue
+			}
+
+			m.eventChan <- &bridge.Event{
+				Type: "channel_create",
+				Data: &bridge.ChannelCreateEvent{
+					ChannelID: channel.Id,
+				},
+			}
+		}
+	}()
+}
+
+// handleWsActionLeaveTeam parts the channels of a team we've left.
+func (m *Mattermost) handleWsActionLeaveTeam(rmsg *model.WebSocketEvent) {
+	teamID, ok := rmsg.Data["team_id"].(string)
+	if !ok || rmsg.Data["user_id"] != m.mc.User.Id {
+		return
+	}
+
+	for _, channel := range m.mc.RemoveTeam(teamID) {
+		// direct messages are shared by all teams
+		if channel.IsGroupOrDirect() {
+			continue
+		}
+
+		m.eventChan <- &bridge.Event{
+			Type: "channel_delete",
+			Data: &bridge.ChannelDeleteEvent{
+				ChannelID: channel.Id,
+			},
+		}
+	}
 }
 
 func (m *Mattermost) handleStatusChangeEvent(rmsg *model.WebSocketEvent) {
@@ -1201,6 +1814,7 @@ func (m *Mattermost) handleReactionEvent(rmsg *model.WebSocketEvent) {
 				ChannelType: channelType,
 				ParentUser:  parentUser,
 				Message:     message,
+				CreateAt:    reaction.CreateAt,
 			},
 		}
 	case model.WEBSOCKET_EVENT_REACTION_REMOVED:
@@ -1233,7 +1847,7 @@ func (m *Mattermost) GetLastViewedAt(channelID string) int64 {
 }
 
 func (m *Mattermost) GetPostsSince(channelID string, since int64) interface{} {
-	return m.mc.GetPostsSince(channelID, since)
+	return m.addPostListAttachments(m.mc.GetPostsSince(channelID, since))
 }
 
 func (m *Mattermost) UpdateLastViewed(channelID string) {
@@ -1257,11 +1871,26 @@ func (m *Mattermost) UpdateLastViewedUser(userID string) error {
 	}
 }
 
-func (m *Mattermost) SearchPosts(search string) interface{} {
-	return m.mc.SearchPosts(search)
+// SearchPosts searches the posts of team (by name), or all our teams when
+// it's empty.
+func (m *Mattermost) SearchPosts(search, team string) interface{} {
+	teamID := ""
+
+	if team != "" {
+		teamID = m.mc.GetTeamIDByName(team)
+		if teamID == "" {
+			return nil
+		}
+	}
+
+	return m.addPostListAttachments(m.mc.SearchPosts(search, teamID))
 }
 
 func (m *Mattermost) GetFileLinks(fileIDs []string) []string {
+	if p := getFileProxy(m.v); p != nil {
+		return m.proxyFileLinks(p, fileIDs, nil)
+	}
+
 	return m.mc.GetFileLinks(fileIDs)
 }
 
@@ -1281,17 +1910,106 @@ func (m *Mattermost) SearchUsers(query string) ([]*bridge.UserInfo, error) {
 }
 
 func (m *Mattermost) GetPosts(channelID string, limit int) interface{} {
-	return m.mc.GetPosts(channelID, limit)
+	return m.addPostListAttachments(m.mc.GetPosts(channelID, limit))
 }
 
+func (m *Mattermost) GetPostThread(postID string) interface{} {
+	return m.addPostListAttachments(m.mc.GetPostThread(postID))
+}
+
+// GetChannelID returns the ID of channel name, names of channels of other
+// teams are prefixed with their team, eg myteam/town-square.
 func (m *Mattermost) GetChannelID(name, teamID string) string {
+	if prefixTeamID, channelName := m.splitTeamChannel(name); prefixTeamID != "" {
+		return m.mc.GetChannelID(channelName, prefixTeamID)
+	}
+
 	return m.mc.GetChannelID(name, teamID)
 }
 
+// splitTeamChannel splits a channel name prefixed with the name of one of our
+// teams (myteam/town-square), teamID is empty if there's no such team.
+func (m *Mattermost) splitTeamChannel(name string) (string, string) {
+	sp := strings.SplitN(name, "/", 2)
+	if len(sp) != 2 {
+		return "", name
+	}
+
+	teamID := m.mc.GetTeamIDByName(sp[0])
+	if teamID == "" {
+		return "", name
+	}
+
+	return teamID, sp[1]
+}
+
+// GetCategory returns the sidebar category of channelID.
+func (m *Mattermost) GetCategory(channelID string) string {
+	return m.mc.GetCategory(channelID)
+}
+
+// IsMuted returns true if we muted channelID.
+func (m *Mattermost) IsMuted(channelID string) bool {
+	return m.mc.IsMuted(channelID)
+}
+
+// IsArchived returns true if channelID is archived, archived channels can
+// only be read.
+func (m *Mattermost) IsArchived(channelID string) bool {
+	return m.mc.IsArchived(channelID)
+}
+
+// ArchiveChannel archives or unarchives channelID.
+func (m *Mattermost) ArchiveChannel(channelID string, archive bool) error {
+	return m.mc.ArchiveChannel(channelID, archive)
+}
+
+// MuteChannel mutes or unmutes channelID.
+func (m *Mattermost) MuteChannel(channelID string, mute bool) error {
+	if m.mc.IsMuted(channelID) == mute {
+		return nil
+	}
+
+	if err := m.mc.MuteChannel(channelID, mute); err != nil {
+		return err
+	}
+
+	m.eventChan <- &bridge.Event{
+		Type: "channel_mute",
+		Data: &bridge.ChannelMuteEvent{
+			ChannelID: channelID,
+			Muted:     mute,
+		},
+	}
+
+	return nil
+}
+
 func (m *Mattermost) Connected() bool {
 	return m.connected
 }
 
+// ExecuteCommand runs a slash command (eg /giphy something) in channelID.
+// The response of the command is sent as an ephemeral message.
+func (m *Mattermost) ExecuteCommand(channelID, command string) error {
+	for {
+		_, resp := m.mc.Client.ExecuteCommand(channelID, command)
+		if resp.Error == nil {
+			return nil
+		}
+
+		if err := m.mc.HandleRatelimit("ExecuteCommand", resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Mattermost) SetMFAToken(token string) error {
+	m.mc.SetMFAToken(token)
+
+	return nil
+}
+
 func Decode(input interface{}, output interface{}) error {
 	config := &mapstructure.DecoderConfig{
 		Metadata: nil,
@@ -0,0 +1,112 @@
+package mattermost
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/42wim/matterircd/pkg/matterclient"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClientSecrets(t *testing.T) {
+	v := viper.New()
+	v.Set("mattermost.SecretSources", []string{"cmd", "env"})
+
+	tests := []struct {
+		Desc       string
+		FromConfig bool
+		Resolved   bool
+	}{
+		{Desc: "PASS from IRC", FromConfig: false, Resolved: false},
+		{Desc: "config", FromConfig: true, Resolved: true},
+	}
+
+	for _, tc := range tests {
+		m := &Mattermost{
+			v: v,
+			credentials: bridge.Credentials{
+				Login:      "me",
+				Pass:       "cmd:echo secret",
+				Server:     "chat.example.com",
+				FromConfig: tc.FromConfig,
+			},
+		}
+
+		mc := m.newClient(nil)
+		assert.Equal(t, "cmd:echo secret", mc.Credentials.Pass, tc.Desc)
+
+		if !tc.Resolved {
+			assert.Nil(t, mc.ResolveSecret, tc.Desc)
+			continue
+		}
+
+		pass, err := mc.ResolveSecret(mc.Credentials.Pass)
+		assert.NoError(t, err, tc.Desc)
+		assert.Equal(t, "secret", pass, tc.Desc)
+	}
+}
+
+func TestGetChannelID(t *testing.T) {
+	mine := &matterclient.Team{
+		Team:     &model.Team{Id: "team1", Name: "myteam"},
+		ID:       "team1",
+		Channels: []*model.Channel{{Id: "dev1", TeamId: "team1", Name: "dev", Type: model.CHANNEL_OPEN}},
+	}
+	other := &matterclient.Team{
+		Team:         &model.Team{Id: "team2", Name: "other"},
+		ID:           "team2",
+		Channels:     []*model.Channel{{Id: "dev2", TeamId: "team2", Name: "dev", Type: model.CHANNEL_OPEN}},
+		MoreChannels: []*model.Channel{{Id: "old2", TeamId: "team2", Name: "old", Type: model.CHANNEL_OPEN, DeleteAt: 1}},
+	}
+
+	m := &Mattermost{
+		v: viper.New(),
+		mc: &matterclient.Client{
+			Team:       mine,
+			OtherTeams: []*matterclient.Team{mine, other},
+		},
+	}
+
+	tests := []struct {
+		Desc     string
+		Name     string
+		Expected string
+	}{
+		{Desc: "our team", Name: "dev", Expected: "dev1"},
+		{Desc: "our team prefixed", Name: "myteam/dev", Expected: "dev1"},
+		{Desc: "other team", Name: "other/dev", Expected: "dev2"},
+		{Desc: "archived in other team", Name: "other/old", Expected: "old2"},
+		{Desc: "unknown team", Name: "nope/dev", Expected: ""},
+		{Desc: "only in other team", Name: "old", Expected: ""},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, m.GetChannelID(tc.Name, "team1"), tc.Desc)
+	}
+}
+
+func TestChannelTopic(t *testing.T) {
+	tests := []struct {
+		Desc        string
+		ShowPurpose bool
+		Header      string
+		Purpose     string
+		Expected    string
+	}{
+		{Desc: "header", Header: "releases", Purpose: "dev talk", Expected: "releases"},
+		{Desc: "header and purpose", ShowPurpose: true, Header: "releases", Purpose: "dev talk", Expected: "releases - dev talk"},
+		{Desc: "only purpose", ShowPurpose: true, Purpose: "dev talk", Expected: "dev talk"},
+		{Desc: "only header", ShowPurpose: true, Header: "releases", Expected: "releases"},
+		{Desc: "empty", ShowPurpose: true, Expected: ""},
+	}
+
+	for _, tc := range tests {
+		v := viper.New()
+		v.Set("mattermost.ShowPurpose", tc.ShowPurpose)
+
+		m := &Mattermost{v: v}
+		assert.Equal(t, tc.Expected, m.channelTopic(tc.Header, tc.Purpose), tc.Desc)
+	}
+}
@@ -0,0 +1,157 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/42wim/matterircd/pkg/matterclient"
+	"github.com/mattermost/mattermost-server/v5/model"
+	logger "github.com/sirupsen/logrus"
+)
+
+// showInThreadChannel returns true if the reply data should be shown in its
+// thread channel (ThreadChannels). That's the case for threads we participate
+// in or were mentioned in, unless we unfollowed them (by parting the thread
+// channel). Replying to a thread follows it again.
+func (m *Mattermost) showInThreadChannel(rmsg *model.WebSocketEvent, data, parentPost *model.Post) bool {
+	if !m.v.GetBool("mattermost.ThreadChannels") || data.ParentId == "" {
+		return false
+	}
+
+	// direct messages are conversations already
+	if channelType, _ := rmsg.Data["channel_type"].(string); channelType == "D" ||
+		strings.Contains(m.GetChannelName(data.ChannelId), "__") {
+		return false
+	}
+
+	me := m.mc.User.Id
+
+	if data.UserId == me {
+		m.setFollowingThread(data.ParentId, true)
+		return true
+	}
+
+	if follow, ok := m.followingThread(data.ParentId); ok {
+		return follow
+	}
+
+	participating := parentPost.UserId == me
+
+	if mentions, ok := rmsg.Data["mentions"].(string); ok && !participating {
+		for _, userID := range model.ArrayFromJson(strings.NewReader(mentions)) {
+			if userID == me {
+				participating = true
+			}
+		}
+	}
+
+	if participating {
+		m.setFollowingThread(data.ParentId, true)
+	}
+
+	return participating
+}
+
+// followingThread returns if we follow the thread of rootID, ok is false when
+// we didn't follow or unfollow it yet.
+func (m *Mattermost) followingThread(rootID string) (follow bool, ok bool) {
+	m.threadsMutex.RLock()
+	defer m.threadsMutex.RUnlock()
+
+	follow, ok = m.threads[rootID]
+
+	return follow, ok
+}
+
+func (m *Mattermost) setFollowingThread(rootID string, follow bool) {
+	m.threadsMutex.Lock()
+	defer m.threadsMutex.Unlock()
+
+	m.threads[rootID] = follow
+}
+
+// FollowThread follows or unfollows the thread of rootID in channelID.
+// Replies of unfollowed threads are shown in the channel again.
+func (m *Mattermost) FollowThread(channelID, rootID string, follow bool) error {
+	m.setFollowingThread(rootID, follow)
+
+	return m.mc.FollowThread(m.mc.GetTeamFromChannel(channelID), rootID, follow)
+}
+
+// threadTopic returns the topic of a thread channel: the root post.
+func (m *Mattermost) threadTopic(rootID string) string {
+	rootPost, resp := m.mc.Client.GetPost(rootID, "")
+	if resp.Error != nil {
+		return ""
+	}
+
+	nick := m.GetUser(rootPost.UserId).Nick
+	message := maybeShorten(rootPost.Message, m.v.GetInt("mattermost.ShortenRepliesTo"), "@", m.v.GetBool("mattermost.unicode"))
+
+	return "thread of @" + nick + ": " + strings.ReplaceAll(message, "\n", " ")
+}
+
+// GetThreads returns the threads we follow, only the ones with unread replies
+// when unread is set.
+func (m *Mattermost) GetThreads(unread bool) ([]*bridge.ThreadInfo, error) {
+	threads, err := m.mc.GetThreads(unread)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*bridge.ThreadInfo
+
+	for _, thread := range threads {
+		infos = append(infos, m.threadInfo(thread))
+	}
+
+	return infos, nil
+}
+
+// MarkThreadRead marks the replies of the thread of rootID in channelID as read.
+func (m *Mattermost) MarkThreadRead(channelID, rootID string) error {
+	return m.mc.MarkThreadRead(m.mc.GetTeamFromChannel(channelID), rootID)
+}
+
+func (m *Mattermost) threadInfo(thread *matterclient.Thread) *bridge.ThreadInfo {
+	return &bridge.ThreadInfo{
+		ID:             thread.ID,
+		ChannelID:      thread.Post.ChannelId,
+		Text:           thread.Post.Message,
+		User:           m.GetUser(thread.Post.UserId),
+		ReplyCount:     thread.ReplyCount,
+		UnreadReplies:  thread.UnreadReplies,
+		UnreadMentions: thread.UnreadMentions,
+		LastReplyAt:    thread.LastReplyAt,
+	}
+}
+
+// handleWsActionThreadUpdated handles updates (eg new replies) of threads we follow.
+func (m *Mattermost) handleWsActionThreadUpdated(rmsg *model.WebSocketEvent) {
+	data, ok := rmsg.Data["thread"].(string)
+	if !ok {
+		return
+	}
+
+	thread := &matterclient.Thread{}
+
+	if err := json.Unmarshal([]byte(data), thread); err != nil || thread.Post == nil {
+		logger.Debugf("thread_updated: couldn't decode thread: %s", data)
+		return
+	}
+
+	m.eventChan <- &bridge.Event{
+		Type: "thread_updated",
+		Data: &bridge.ThreadUpdateEvent{
+			Thread: m.threadInfo(thread),
+		},
+	}
+}
+
+// markThreadRead marks a thread as read after we replied to it.
+func (m *Mattermost) markThreadRead(channelID, rootID string) {
+	if err := m.MarkThreadRead(channelID, rootID); err != nil {
+		logger.Debugf("marking thread %s as read failed: %s", rootID, err)
+	}
+}
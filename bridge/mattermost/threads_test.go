@@ -0,0 +1,51 @@
+package mattermost
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/pkg/matterclient"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowInThreadChannel(t *testing.T) {
+	v := viper.New()
+	v.Set("mattermost.ThreadChannels", true)
+
+	team := &matterclient.Team{
+		Team:     &model.Team{Id: "team", Name: "myteam"},
+		ID:       "team",
+		Channels: []*model.Channel{{Id: "chan", TeamId: "team", Name: "dev", Type: model.CHANNEL_OPEN}},
+	}
+
+	m := &Mattermost{
+		v:       v,
+		threads: make(map[string]bool),
+		mc: &matterclient.Client{
+			User:       &model.User{Id: "me"},
+			Team:       team,
+			OtherTeams: []*matterclient.Team{team},
+		},
+	}
+
+	ws := &model.WebSocketEvent{Data: map[string]interface{}{"channel_type": "O"}}
+	mine := &model.Post{Id: "root", UserId: "me", ChannelId: "chan"}
+	reply := &model.Post{Id: "reply", ParentId: "root", UserId: "bob", ChannelId: "chan"}
+	myReply := &model.Post{Id: "reply2", ParentId: "root", UserId: "me", ChannelId: "chan"}
+
+	assert.True(t, m.showInThreadChannel(ws, reply, mine), "thread we started")
+
+	m.setFollowingThread("root", false)
+	assert.False(t, m.showInThreadChannel(ws, reply, mine), "unfollowed thread we started")
+
+	assert.True(t, m.showInThreadChannel(ws, myReply, mine), "our reply follows again")
+	assert.True(t, m.showInThreadChannel(ws, reply, mine))
+
+	other := &model.Post{Id: "other", UserId: "alice", ChannelId: "chan"}
+	otherReply := &model.Post{Id: "reply3", ParentId: "other", UserId: "bob", ChannelId: "chan"}
+	assert.False(t, m.showInThreadChannel(ws, otherReply, other), "not participating")
+
+	ws.Data["mentions"] = `["me"]`
+	assert.True(t, m.showInThreadChannel(ws, otherReply, other), "mentioned")
+}
@@ -1,6 +1,7 @@
 package slack
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"strings"
@@ -8,6 +9,8 @@ import (
 	"time"
 
 	"github.com/42wim/matterircd/bridge"
+	"github.com/42wim/matterircd/pkg/emoji"
+	"github.com/42wim/matterircd/pkg/secret"
 	"github.com/davecgh/go-spew/spew"
 	logger "github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
@@ -84,8 +87,16 @@ func (s *Slack) Join(channelName string) (string, string, error) {
 	return mychan.ID, mychan.Topic.Value, nil
 }
 
-func (s *Slack) List() (map[string]string, error) {
-	channelinfo := make(map[string]string)
+func (s *Slack) CreateChannel(channelName string, private bool) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (s *Slack) CreateGroup(userIDs []string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (s *Slack) List() ([]*bridge.ChannelInfo, error) {
+	var channelinfo []*bridge.ChannelInfo
 
 	params := slack.GetConversationsParameters{
 		Cursor:          "",
@@ -94,16 +105,30 @@ func (s *Slack) List() (map[string]string, error) {
 		Types:           []string{"public_channel", "private_channel", "mpim"},
 	}
 
-OUTER:
 	for {
-		conversations, nextCursor, _ := s.sc.GetConversations(&params)
+		conversations, nextCursor, err := s.sc.GetConversations(&params)
+		if err != nil {
+			return nil, err
+		}
+
 		params.Cursor = nextCursor
 
 		for _, channel := range conversations {
-			channelinfo["#"+channel.Name] = strings.ReplaceAll(channel.Topic.Value, "\n", " | ")
-			if nextCursor == "" {
-				break OUTER
-			}
+			channelinfo = append(channelinfo, &bridge.ChannelInfo{
+				Name:     "#" + channel.Name,
+				ID:       channel.ID,
+				Private:  channel.IsPrivate,
+				Archived: channel.IsArchived,
+				Topic:    strings.ReplaceAll(channel.Topic.Value, "\n", " | "),
+				Purpose:  strings.ReplaceAll(channel.Purpose.Value, "\n", " | "),
+				CreateAt: int64(channel.Created) * 1000,
+				TopicAt:  int64(channel.Topic.LastSet) * 1000,
+				Users:    channel.NumMembers,
+			})
+		}
+
+		if nextCursor == "" {
+			break
 		}
 	}
 
@@ -180,6 +205,44 @@ func (s *Slack) MsgUser(username, text string) (string, error) {
 	return msgID, nil
 }
 
+func (s *Slack) UploadUser(userID, filename string, data []byte, text string) (string, error) {
+	dchannel, _, _, err := s.sc.OpenConversation(&slack.OpenConversationParameters{
+		Users: []string{userID},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return s.UploadChannel(dchannel.ID, filename, data, text)
+}
+
+func (s *Slack) UploadChannel(channelID, filename string, data []byte, text string) (string, error) {
+	file, err := s.sc.UploadFile(slack.FileUploadParameters{
+		Reader:         bytes.NewReader(data),
+		Filename:       filename,
+		Title:          filename,
+		InitialComment: text,
+		Channels:       []string{strings.ToUpper(channelID)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fileMessageID(file, strings.ToUpper(channelID)), nil
+}
+
+// fileMessageID returns the ID (timestamp) of the message that shared file in
+// channelID, or "" if it isn't shared there (yet).
+func fileMessageID(file *slack.File, channelID string) string {
+	for _, shares := range []map[string][]slack.ShareFileInfo{file.Shares.Public, file.Shares.Private} {
+		if infos := shares[channelID]; len(infos) > 0 {
+			return infos[0].Ts
+		}
+	}
+
+	return ""
+}
+
 func (s *Slack) MsgChannel(channelID, text string) (string, error) {
 	opts := s.createSlackMsgOption(text)
 
@@ -237,6 +300,18 @@ func (s *Slack) SetStatus(status string) error {
 	return nil
 }
 
+func (s *Slack) SetDND(until time.Duration) error {
+	return errors.New("not implemented")
+}
+
+func (s *Slack) SetCustomStatus(emoji, text string, expires time.Duration) error {
+	return errors.New("not implemented")
+}
+
+func (s *Slack) GetCustomStatus(userID string) string {
+	return ""
+}
+
 func (s *Slack) Nick(name string) error {
 	return nil
 }
@@ -254,6 +329,29 @@ func (s *Slack) GetChannelName(channelID string) string {
 	return name
 }
 
+func (s *Slack) GetChannelMemberCount(channelID string) (int, error) {
+	count := 0
+
+	params := slack.GetUsersInConversationParameters{
+		ChannelID: channelID,
+		Limit:     1000,
+	}
+
+	for {
+		members, nextCursor, err := s.sc.GetUsersInConversation(&params)
+		if err != nil {
+			return 0, err
+		}
+
+		count += len(members)
+		params.Cursor = nextCursor
+
+		if nextCursor == "" {
+			return count, nil
+		}
+	}
+}
+
 func (s *Slack) GetChannelUsers(channelID string) ([]*bridge.UserInfo, error) {
 	var users []*bridge.UserInfo
 
@@ -376,6 +474,10 @@ func (s *Slack) GetUserByUsername(username string) *bridge.UserInfo {
 	return nil
 }
 
+func (s *Slack) GetCachedUserByUsername(username string) *bridge.UserInfo {
+	return nil
+}
+
 func (s *Slack) GetTeamName(teamID string) string {
 	return s.sinfo.Team.Name
 }
@@ -388,7 +490,7 @@ func (s *Slack) GetPostsSince(channelID string, since int64) interface{} {
 	return nil
 }
 
-func (s *Slack) SearchPosts(search string) interface{} {
+func (s *Slack) SearchPosts(search, team string) interface{} {
 	return nil
 }
 
@@ -411,6 +513,10 @@ func (s *Slack) GetPosts(channelID string, limit int) interface{} {
 	return nil
 }
 
+func (s *Slack) GetPostThread(postID string) interface{} {
+	return nil
+}
+
 func (s *Slack) GetChannelID(name, teamID string) string {
 	return ""
 }
@@ -450,22 +556,52 @@ func (s *Slack) allowedLogin() error {
 	return nil
 }
 
+// resolveCredentials returns our credentials with the secret references
+// resolved, the references are kept in s.credentials so they never end up in
+// the IRC stream. Only credentials from the configuration file are resolved,
+// those sent by an IRC client would allow anyone to read files or run commands.
+func (s *Slack) resolveCredentials() (bridge.Credentials, error) {
+	cred := s.credentials
+	if !cred.FromConfig {
+		return cred, nil
+	}
+
+	resolver := secret.New(s.v.GetStringSlice("slack.SecretSources"))
+
+	for _, field := range []*string{&cred.Login, &cred.Pass, &cred.Token} {
+		var err error
+
+		if *field, err = resolver.Resolve(*field); err != nil {
+			return cred, err
+		}
+	}
+
+	return cred, nil
+}
+
 func (s *Slack) loginToSlack() (*slack.Client, error) {
 	var err error
 
-	if s.credentials.Token == "" {
-		s.credentials.Token, err = s.getSlackToken()
+	cred, err := s.resolveCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	if cred.Token == "" {
+		cred.Token, err = s.getSlackToken(cred)
 		if err != nil {
 			return nil, err
 		}
+
+		s.credentials.Token = cred.Token
 	}
 
 	var cookie string
 
-	token := s.credentials.Token
+	token := cred.Token
 
-	if strings.HasPrefix(s.credentials.Token, "xoxc") {
-		token, cookie, err = passwordToTokenAndCookie(s.credentials.Token)
+	if strings.HasPrefix(token, "xoxc") {
+		token, cookie, err = passwordToTokenAndCookie(token)
 		if err != nil {
 			return nil, err
 		}
@@ -749,6 +885,10 @@ func (s *Slack) handleSlackActionPost(rmsg *slack.MessageEvent) {
 		rmsg.Text = "[M " + ts + "] Message deleted"
 	}
 
+	if s.v.GetBool("slack.unicode") {
+		rmsg.Text = emoji.ToUnicode(rmsg.Text)
+	}
+
 	// TODO: cache userinfo
 	suser, err := s.getSlackUserFromMessage(rmsg)
 	if err != nil {
@@ -910,6 +1050,18 @@ func (s *Slack) userName(id string) string {
 	return ""
 }
 
+// mentionName returns the name a mention of user id is shown as, the nick of
+// its ghost with MentionToNick.
+func (s *Slack) mentionName(id string) string {
+	if s.v.GetBool("slack.MentionToNick") {
+		if user := s.getSlackUser(id); user != nil {
+			return s.createUser(user).Nick
+		}
+	}
+
+	return s.userName(id)
+}
+
 func (s *Slack) getSlackUser(userID string) *slack.User {
 	s.RLock()
 	defer s.RUnlock()
@@ -960,3 +1112,68 @@ func (s *Slack) AddReaction(msgID, emoji string) error {
 func (s *Slack) RemoveReaction(msgID, emoji string) error {
 	return nil
 }
+
+func (s *Slack) SetMFAToken(token string) error {
+	return nil
+}
+
+func (s *Slack) ExecuteCommand(channelID, command string) error {
+	return errors.New("not implemented")
+}
+
+func (s *Slack) GetCategory(channelID string) string {
+	return ""
+}
+
+func (s *Slack) IsMuted(channelID string) bool {
+	return false
+}
+
+func (s *Slack) MuteChannel(channelID string, mute bool) error {
+	return errors.New("not implemented")
+}
+
+func (s *Slack) GetUserProfile(userID string) (*bridge.UserProfile, error) {
+	user := s.getSlackUser(userID)
+	if user == nil {
+		return nil, errors.New("user " + userID + " not found")
+	}
+
+	return &bridge.UserProfile{
+		DisplayName: user.Profile.DisplayName,
+		Position:    user.Profile.Title,
+		Email:       user.Profile.Email,
+		Timezone:    user.TZ,
+		Bot:         user.IsBot,
+		Guest:       user.IsRestricted || user.IsUltraRestricted,
+		Deactivated: user.Deleted,
+	}, nil
+}
+
+func (s *Slack) IsArchived(channelID string) bool {
+	return false
+}
+
+func (s *Slack) ArchiveChannel(channelID string, archive bool) error {
+	if archive {
+		return s.sc.ArchiveConversation(channelID)
+	}
+
+	return s.sc.UnArchiveConversation(channelID)
+}
+
+func (s *Slack) DoPostAction(postID, button, option string) error {
+	return errors.New("not implemented")
+}
+
+func (s *Slack) FollowThread(channelID, rootID string, follow bool) error {
+	return errors.New("not implemented")
+}
+
+func (s *Slack) GetThreads(unread bool) ([]*bridge.ThreadInfo, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *Slack) MarkThreadRead(channelID, rootID string) error {
+	return errors.New("not implemented")
+}
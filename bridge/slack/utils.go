@@ -12,11 +12,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/42wim/matterircd/bridge"
 	logger "github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
 )
 
-func (s *Slack) getSlackToken() (string, error) {
+func (s *Slack) getSlackToken(cred bridge.Credentials) (string, error) {
 	type findTeamResponseFull struct {
 		SSO    bool   `json:"sso"`
 		TeamID string `json:"team_id"`
@@ -28,7 +29,7 @@ func (s *Slack) getSlackToken() (string, error) {
 		slack.SlackResponse
 	}
 
-	resp, err := http.PostForm("https://slack.com/api/auth.findTeam", url.Values{"domain": {s.credentials.Team}})
+	resp, err := http.PostForm("https://slack.com/api/auth.findTeam", url.Values{"domain": {cred.Team}})
 	if err != nil {
 		return "", err
 	}
@@ -52,7 +53,7 @@ func (s *Slack) getSlackToken() (string, error) {
 	}
 
 	resp, err = http.PostForm("https://slack.com/api/auth.signin",
-		url.Values{"team": {findTeamResponse.TeamID}, "email": {s.credentials.Login}, "password": {s.credentials.Pass}})
+		url.Values{"team": {findTeamResponse.TeamID}, "email": {cred.Login}, "password": {cred.Pass}})
 	if err != nil {
 		return "", err
 	}
@@ -131,7 +132,7 @@ func formatTS(unixts string) string {
 func (s *Slack) replaceMention(text string) string {
 	results := regexp.MustCompile(`<@([a-zA-z0-9]+)>`).FindAllStringSubmatch(text, -1)
 	for _, r := range results {
-		text = strings.ReplaceAll(text, "<@"+r[1]+">", "@"+s.userName(r[1]))
+		text = strings.ReplaceAll(text, "<@"+r[1]+">", "@"+s.mentionName(r[1]))
 	}
 
 	return text
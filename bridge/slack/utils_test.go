@@ -0,0 +1,44 @@
+package slack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanupMessageMentions(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":false,"error":"user_not_found"}`))
+	}))
+	defer api.Close()
+
+	bob := slack.User{ID: "U1234", Name: "robert"}
+	bob.Profile.DisplayName = "Bob Smith"
+
+	ann := slack.User{ID: "U5678", Name: "ann.smith"}
+	ann.Profile.DisplayName = "ann"
+
+	newSlack := func(mentionToNick bool) *Slack {
+		v := viper.New()
+		v.Set("slack.MentionToNick", mentionToNick)
+		v.Set("slack.PreferNickname", true)
+
+		return &Slack{
+			v:      v,
+			sc:     slack.New("xoxp-test", slack.OptionAPIURL(api.URL+"/")),
+			sinfo:  &slack.Info{User: &slack.UserDetails{ID: "U0000", Name: "me"}, Team: &slack.Team{ID: "T0000"}},
+			susers: map[string]slack.User{bob.ID: bob, ann.ID: ann},
+		}
+	}
+
+	text := "<@U1234>: ask <@U5678> and <!here>, not <@U9999>"
+
+	assert.Equal(t, "@Bob Smith: ask @ann and @here, not @", newSlack(false).cleanupMessage(text))
+	// the nicks of the ghosts, Bob Smith isn't a valid nick
+	assert.Equal(t, "@robert: ask @ann and @here, not @", newSlack(true).cleanupMessage(text))
+}
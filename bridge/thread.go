@@ -0,0 +1,35 @@
+package bridge
+
+import "strings"
+
+// threadChannelSep separates the channel and the root post of a thread
+// channel, eg #town-square/t-abc123
+const threadChannelSep = "/t-"
+
+// threadChannelNameLen is the length of the root post ID used in thread channel names.
+const threadChannelNameLen = 6
+
+// ThreadChannelID returns the ID of the channel showing the thread of rootID in channelID.
+func ThreadChannelID(channelID, rootID string) string {
+	return channelID + threadChannelSep + rootID
+}
+
+// ParseThreadChannelID returns the channel ID and root post ID of a thread
+// channel, ok is false if id isn't a thread channel.
+func ParseThreadChannelID(id string) (channelID, rootID string, ok bool) {
+	idx := strings.LastIndex(id, threadChannelSep)
+	if idx < 1 || idx+len(threadChannelSep) == len(id) {
+		return "", "", false
+	}
+
+	return id[:idx], id[idx+len(threadChannelSep):], true
+}
+
+// ThreadChannelName returns the name of the thread channel of rootID in channelName.
+func ThreadChannelName(channelName, rootID string) string {
+	if len(rootID) > threadChannelNameLen {
+		rootID = rootID[:threadChannelNameLen]
+	}
+
+	return channelName + threadChannelSep + rootID
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThreadChannelID(t *testing.T) {
+	tests := []struct {
+		Desc      string
+		Value     string
+		ChannelID string
+		RootID    string
+		IsGood    bool
+	}{
+		{
+			Desc:      "thread channel",
+			Value:     ThreadChannelID("jmgsx9ha8ib8pxmu67uh5npbwc", "cfrakpwix7y8pgzux6ta76pm9c"),
+			ChannelID: "jmgsx9ha8ib8pxmu67uh5npbwc",
+			RootID:    "cfrakpwix7y8pgzux6ta76pm9c",
+			IsGood:    true,
+		},
+		{
+			Desc:  "normal channel",
+			Value: "jmgsx9ha8ib8pxmu67uh5npbwc",
+		},
+		{
+			Desc:  "special channel",
+			Value: "&messages",
+		},
+		{
+			Desc:  "no root",
+			Value: "jmgsx9ha8ib8pxmu67uh5npbwc/t-",
+		},
+	}
+
+	for _, tc := range tests {
+		channelID, rootID, ok := ParseThreadChannelID(tc.Value)
+		assert.Equal(t, tc.IsGood, ok, tc.Desc)
+		assert.Equal(t, tc.ChannelID, channelID, tc.Desc)
+		assert.Equal(t, tc.RootID, rootID, tc.Desc)
+	}
+}
+
+func TestThreadChannelName(t *testing.T) {
+	assert.Equal(t, "#town-square/t-cfrakp", ThreadChannelName("#town-square", "cfrakpwix7y8pgzux6ta76pm9c"))
+	assert.Equal(t, "#myteam/town-square/t-cfrakp", ThreadChannelName("#myteam/town-square", "cfrakpwix7y8pgzux6ta76pm9c"))
+}
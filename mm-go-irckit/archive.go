@@ -0,0 +1,356 @@
+package irckit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+)
+
+// localSearchLimit is the maximum number of (newest) matches LOCALSEARCH shows.
+const localSearchLimit = 50
+
+// archiveQueueSize is the number of records waiting to be written before
+// archiving blocks the events.
+const archiveQueueSize = 1000
+
+// archiveRecord is a message, edit, delete or reaction we've seen, one JSON
+// line in the archive.
+type archiveRecord struct {
+	Time      int64  `json:"time"`  // when it was posted (or we saw it), in milliseconds
+	Event     string `json:"event"` // posted, post_edited, post_deleted, reaction_added or reaction_removed
+	ChannelID string `json:"channel_id"`
+	Channel   string `json:"channel,omitempty"` // #channel, or the nick of a direct message
+	MessageID string `json:"message_id,omitempty"`
+	ParentID  string `json:"parent_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	Nick      string `json:"nick,omitempty"`
+	Text      string `json:"text,omitempty"`
+}
+
+// archivePath returns the archive of the logged in account, next to its state
+// file, or "" when there's no archive (Archive or StateDir isn't set).
+func (u *User) archivePath() string {
+	if !u.v.GetBool(u.br.Protocol()+".archive") || u.statePath == "" {
+		return ""
+	}
+
+	return strings.TrimSuffix(u.statePath, ".json") + ".archive.jsonl"
+}
+
+// archiveEvent appends the messages, files and reactions of event to the archive.
+func (u *User) archiveEvent(event *bridge.Event) {
+	path := u.archivePath()
+	if path == "" {
+		return
+	}
+
+	var rec *archiveRecord
+
+	switch e := event.Data.(type) {
+	case *bridge.ChannelMessageEvent:
+		rec = &archiveRecord{Time: e.CreateAt, Event: e.Event, ChannelID: e.ChannelID, Channel: u.archiveChannelName(e.ChannelID),
+			MessageID: e.MessageID, ParentID: e.ParentID, Text: e.Text}
+		rec.setSender(e.Sender)
+	case *bridge.DirectMessageEvent:
+		rec = &archiveRecord{Time: e.CreateAt, Event: e.Event, ChannelID: e.ChannelID, MessageID: e.MessageID,
+			ParentID: e.ParentID, Text: e.Text}
+		rec.setSender(e.Sender)
+
+		if other := e.Sender; other != nil && e.Receiver != nil {
+			if other.Me {
+				other = e.Receiver
+			}
+
+			rec.Channel = other.Nick
+		}
+	case *bridge.FileEvent:
+		var names []string
+
+		for _, f := range e.Files {
+			names = append(names, f.Name)
+		}
+
+		rec = &archiveRecord{Time: e.CreateAt, ChannelID: e.ChannelID, Channel: u.archiveChannelName(e.ChannelID),
+			MessageID: e.MessageID, ParentID: e.ParentID, Text: "files: " + strings.Join(names, " ")}
+		rec.setSender(e.Sender)
+	case *bridge.ReactionAddEvent:
+		rec = &archiveRecord{Time: e.CreateAt, Event: "reaction_added", ChannelID: e.ChannelID, Channel: u.archiveChannelName(e.ChannelID),
+			MessageID: e.MessageID, Text: ":" + e.Reaction + ":"}
+		rec.setSender(e.Sender)
+	case *bridge.ReactionRemoveEvent:
+		rec = &archiveRecord{Event: "reaction_removed", ChannelID: e.ChannelID, Channel: u.archiveChannelName(e.ChannelID),
+			MessageID: e.MessageID, Text: ":" + e.Reaction + ":"}
+		rec.setSender(e.Sender)
+	default:
+		return
+	}
+
+	if rec.Event == "" {
+		rec.Event = "posted"
+	}
+
+	if rec.Time == 0 {
+		rec.Time = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	u.archiveMutex.Lock()
+	defer u.archiveMutex.Unlock()
+
+	// the archive changes when we log in to another account
+	if u.archiveWriter == nil || u.archiveWriter.path != path {
+		if u.archiveWriter != nil {
+			u.archiveWriter.close()
+		}
+
+		u.archiveWriter = newArchiveWriter(path)
+	}
+
+	u.archiveWriter.records <- rec
+}
+
+// closeArchive stops writing the archive, after the records waiting.
+func (u *User) closeArchive() {
+	u.archiveMutex.Lock()
+	defer u.archiveMutex.Unlock()
+
+	if u.archiveWriter != nil {
+		u.archiveWriter.close()
+		u.archiveWriter = nil
+	}
+}
+
+func (rec *archiveRecord) setSender(sender *bridge.UserInfo) {
+	if sender != nil {
+		rec.UserID = sender.User
+		rec.Nick = sender.Nick
+	}
+}
+
+// archiveChannelName returns the name of channelID as shown on IRC, for direct
+// messages the nick of the other user.
+func (u *User) archiveChannelName(channelID string) string {
+	name := u.br.GetChannelName(channelID)
+	if !strings.Contains(name, "__") {
+		return name
+	}
+
+	return u.br.GetUser(u.contextChannelID(&bridge.ChannelInfo{ID: channelID, Name: name})).Nick
+}
+
+// archiveWriter appends records to an archive, so archiving doesn't wait for
+// the disk.
+type archiveWriter struct {
+	path    string
+	records chan *archiveRecord
+	done    chan struct{}
+}
+
+func newArchiveWriter(path string) *archiveWriter {
+	w := &archiveWriter{
+		path:    path,
+		records: make(chan *archiveRecord, archiveQueueSize),
+		done:    make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+func (w *archiveWriter) run() {
+	defer close(w.done)
+
+	var f *os.File
+
+	for rec := range w.records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			logger.Errorf("archiving to %s failed: %s", w.path, err)
+			continue
+		}
+
+		// (re)open the archive when needed, it's kept open until we're closed
+		if f == nil {
+			f, err = os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+			if err != nil {
+				logger.Errorf("archiving to %s failed: %s", w.path, err)
+				f = nil
+
+				continue
+			}
+		}
+
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			logger.Errorf("archiving to %s failed: %s", w.path, err)
+			f.Close()
+			f = nil
+		}
+	}
+
+	if f != nil {
+		f.Close()
+	}
+}
+
+// close stops w after writing the records waiting.
+func (w *archiveWriter) close() {
+	close(w.records)
+	<-w.done
+}
+
+// archiveFilter is a parsed LOCALSEARCH query.
+type archiveFilter struct {
+	words         []string // all must be in the text (case insensitive)
+	from, in      string
+	event         string
+	before, after int64 // in milliseconds, 0 when not set
+}
+
+// parseArchiveFilter parses the LOCALSEARCH arguments: words and
+// from:<nick> in:<#channel|nick> before:|after:|on:<yyyy-mm-dd> is:<edited|deleted|reaction>.
+func parseArchiveFilter(args []string, loc *time.Location) (*archiveFilter, error) {
+	f := &archiveFilter{}
+
+	for _, arg := range args {
+		key, value := "", arg
+		if i := strings.Index(arg, ":"); i > 0 {
+			key, value = strings.ToLower(arg[:i]), arg[i+1:]
+		}
+
+		switch key {
+		case "from":
+			f.from = strings.TrimPrefix(value, "@")
+		case "in":
+			f.in = value
+		case "before", "after", "on":
+			day, err := time.ParseInLocation("2006-01-02", value, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date %s, use yyyy-mm-dd", value)
+			}
+
+			start := day.UnixNano() / int64(time.Millisecond)
+			end := day.AddDate(0, 0, 1).UnixNano() / int64(time.Millisecond)
+
+			switch key {
+			case "before":
+				f.before = start
+			case "after":
+				f.after = end
+			default:
+				f.after, f.before = start, end
+			}
+		case "is":
+			switch strings.ToLower(value) {
+			case "edited":
+				f.event = "post_edited"
+			case "deleted":
+				f.event = "post_deleted"
+			case "reaction":
+				f.event = "reaction"
+			default:
+				return nil, fmt.Errorf("unknown is:%s, use is:edited, is:deleted or is:reaction", value)
+			}
+		default:
+			f.words = append(f.words, strings.ToLower(arg))
+		}
+	}
+
+	return f, nil
+}
+
+// match returns true if rec matches all the filters.
+func (f *archiveFilter) match(rec *archiveRecord) bool {
+	switch {
+	case f.from != "" && !strings.EqualFold(f.from, rec.Nick):
+		return false
+	case f.in != "" && !strings.EqualFold(f.in, rec.Channel):
+		return false
+	case f.event == "reaction" && !strings.HasPrefix(rec.Event, "reaction_"):
+		return false
+	case f.event != "" && f.event != "reaction" && f.event != rec.Event:
+		return false
+	case f.before != 0 && rec.Time >= f.before:
+		return false
+	case f.after != 0 && rec.Time < f.after:
+		return false
+	}
+
+	text := strings.ToLower(rec.Text)
+
+	for _, word := range f.words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// searchArchive returns the newest (at most limit) records of r matching f,
+// oldest first. Lines that can't be decoded are skipped.
+func searchArchive(r io.Reader, f *archiveFilter, limit int) ([]*archiveRecord, int, error) {
+	var (
+		matches []*archiveRecord
+		total   int
+	)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		rec := &archiveRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil || !f.match(rec) {
+			continue
+		}
+
+		total++
+
+		matches = append(matches, rec)
+		if len(matches) > limit {
+			matches = matches[1:]
+		}
+	}
+
+	return matches, total, scanner.Err()
+}
+
+// searchArchiveFile searches the archive at path, see searchArchive. The
+// archive is only appended to, so it's read while being written: a partly
+// written last line can't be decoded and is skipped.
+func searchArchiveFile(path string, f *archiveFilter) ([]*archiveRecord, int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer file.Close()
+
+	return searchArchive(file, f, localSearchLimit)
+}
+
+// formatArchiveRecord formats rec, eg
+// #town-square 2021-06-01 15:04 <nick> can someone review my PR?
+func formatArchiveRecord(rec *archiveRecord, loc *time.Location) string {
+	ts := time.Unix(0, rec.Time*int64(time.Millisecond)).In(loc).Format("2006-01-02 15:04")
+
+	text := rec.Text
+
+	switch rec.Event {
+	case "reaction_added":
+		text = "added reaction " + text
+	case "reaction_removed":
+		text = "removed reaction " + text
+	}
+
+	return fmt.Sprintf("%s %s <%s> %s", rec.Channel, ts, rec.Nick, text)
+}
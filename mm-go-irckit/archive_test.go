@@ -0,0 +1,147 @@
+package irckit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchArchive(t *testing.T) {
+	day := func(d, hour int) int64 {
+		return time.Date(2021, 6, d, hour, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	}
+
+	records := []*archiveRecord{
+		{Time: day(1, 10), Event: "posted", Channel: "#dev", Nick: "bob", Text: "release is Friday"},
+		{Time: day(1, 11), Event: "post_edited", Channel: "#dev", Nick: "bob", Text: "release is Monday (edited)"},
+		{Time: day(2, 9), Event: "reaction_added", Channel: "#dev", Nick: "alice", Text: ":thumbsup:"},
+		{Time: day(3, 12), Event: "posted", Channel: "bob", Nick: "alice", Text: "can you do the Release?"},
+	}
+
+	var archive strings.Builder
+
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		assert.NoError(t, err)
+		archive.Write(append(line, '\n'))
+	}
+
+	archive.WriteString("not json\n")
+
+	tests := []struct {
+		Desc     string
+		Args     []string
+		Limit    int
+		Expected []*archiveRecord
+		Total    int
+	}{
+		{Desc: "words", Args: []string{"RELEASE"}, Limit: 10, Expected: []*archiveRecord{records[0], records[1], records[3]}, Total: 3},
+		{Desc: "all words", Args: []string{"release", "monday"}, Limit: 10, Expected: []*archiveRecord{records[1]}, Total: 1},
+		{Desc: "newest only", Args: []string{"release"}, Limit: 1, Expected: []*archiveRecord{records[3]}, Total: 3},
+		{Desc: "from", Args: []string{"from:@Alice"}, Limit: 10, Expected: []*archiveRecord{records[2], records[3]}, Total: 2},
+		{Desc: "in direct message", Args: []string{"in:bob"}, Limit: 10, Expected: []*archiveRecord{records[3]}, Total: 1},
+		{Desc: "in channel", Args: []string{"in:#dev", "release"}, Limit: 10, Expected: []*archiveRecord{records[0], records[1]}, Total: 2},
+		{Desc: "edited", Args: []string{"is:edited"}, Limit: 10, Expected: []*archiveRecord{records[1]}, Total: 1},
+		{Desc: "reactions", Args: []string{"is:reaction"}, Limit: 10, Expected: []*archiveRecord{records[2]}, Total: 1},
+		{Desc: "on", Args: []string{"on:2021-06-02"}, Limit: 10, Expected: []*archiveRecord{records[2]}, Total: 1},
+		{Desc: "after and before", Args: []string{"after:2021-06-01", "before:2021-06-03"}, Limit: 10, Expected: []*archiveRecord{records[2]}, Total: 1},
+		{Desc: "no match", Args: []string{"deploy"}, Limit: 10, Total: 0},
+	}
+
+	for _, tc := range tests {
+		f, err := parseArchiveFilter(tc.Args, time.UTC)
+		assert.NoError(t, err, tc.Desc)
+
+		matches, total, err := searchArchive(strings.NewReader(archive.String()), f, tc.Limit)
+		assert.NoError(t, err, tc.Desc)
+		assert.Equal(t, tc.Expected, matches, tc.Desc)
+		assert.Equal(t, tc.Total, total, tc.Desc)
+	}
+}
+
+func TestParseArchiveFilterErrors(t *testing.T) {
+	for _, args := range [][]string{{"before:yesterday"}, {"is:pinned"}} {
+		_, err := parseArchiveFilter(args, time.UTC)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestFormatArchiveRecord(t *testing.T) {
+	at := time.Date(2021, 6, 1, 15, 4, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+
+	assert.Equal(t, "#dev 2021-06-01 15:04 <bob> hello",
+		formatArchiveRecord(&archiveRecord{Time: at, Event: "posted", Channel: "#dev", Nick: "bob", Text: "hello"}, time.UTC))
+	assert.Equal(t, "#dev 2021-06-01 15:04 <bob> added reaction :tada:",
+		formatArchiveRecord(&archiveRecord{Time: at, Event: "reaction_added", Channel: "#dev", Nick: "bob", Text: ":tada:"}, time.UTC))
+}
+
+func TestArchiveWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "matterircd")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "me.archive.jsonl")
+
+	records := []*archiveRecord{
+		{Time: 1, Event: "posted", Channel: "#dev", Nick: "bob", Text: "release is Friday"},
+		{Time: 2, Event: "posted", Channel: "#dev", Nick: "alice", Text: "release what?"},
+	}
+
+	w := newArchiveWriter(path)
+	for _, rec := range records {
+		w.records <- rec
+	}
+	w.close()
+
+	// a record being written while we search
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.NoError(t, err)
+	f.WriteString(`{"time":3,"event":"posted","text":"release`)
+	f.Close()
+
+	filter, err := parseArchiveFilter([]string{"release"}, time.UTC)
+	assert.NoError(t, err)
+
+	matches, total, err := searchArchiveFile(path, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, records, matches)
+	assert.Equal(t, 2, total)
+
+	matches, total, err = searchArchiveFile(filepath.Join(dir, "none.archive.jsonl"), filter)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+	assert.Equal(t, 0, total)
+}
+
+func TestArchiveEventTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "matterircd")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	u := newBridgeUser(&fakeBridge{channels: map[string]*bridge.ChannelInfo{"dev": {ID: "dev", Name: "dev"}}})
+	u.v.Set("mattermost.Archive", true)
+	u.statePath = filepath.Join(dir, "me.json")
+
+	sender := &bridge.UserInfo{Nick: "bob", User: "bob"}
+	posted := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+
+	u.archiveEvent(&bridge.Event{Data: &bridge.ChannelMessageEvent{ChannelID: "dev", Sender: sender, Text: "old", CreateAt: posted}})
+	u.archiveEvent(&bridge.Event{Data: &bridge.ChannelMessageEvent{ChannelID: "dev", Sender: sender, Text: "new"}})
+	u.closeArchive()
+
+	matches, total, err := searchArchiveFile(u.archivePath(), &archiveFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, posted, matches[0].Time, "the time it was posted")
+	assert.Equal(t, "#dev", matches[0].Channel)
+	assert.Greater(t, matches[1].Time, posted, "the time we saw it")
+}
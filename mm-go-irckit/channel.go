@@ -73,6 +73,9 @@ type Channel interface {
 	SpoofNotice(from string, text string)
 
 	IsPrivate() bool
+
+	// SetPrivate changes the private (+p) mode of the channel on behalf of Prefixer.
+	SetPrivate(from Prefixer, private bool)
 }
 
 type channel struct {
@@ -475,3 +478,37 @@ func (ch *channel) IsPrivate() bool {
 
 	return ch.private
 }
+
+func (ch *channel) SetPrivate(from Prefixer, private bool) {
+	ch.mu.Lock()
+
+	if ch.private == private {
+		ch.mu.Unlock()
+		return
+	}
+
+	ch.private = private
+
+	ch.mu.Unlock()
+
+	mode := "-p"
+	if private {
+		mode = "+p"
+	}
+
+	msg := &irc.Message{
+		Prefix:  from.Prefix(),
+		Command: irc.MODE,
+		Params:  []string{ch.name, mode},
+	}
+
+	ch.mu.RLock()
+
+	for _, to := range ch.usersIdx {
+		if !to.Ghost {
+			to.Encode(msg)
+		}
+	}
+
+	ch.mu.RUnlock()
+}
@@ -0,0 +1,207 @@
+package irckit
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+)
+
+// listFilter is a parsed ELIST filter of the /LIST command, eg
+// "#dev*,!*-old,>10,C<1440": channels matching a mask, not matching a
+// negated mask, with more than 10 users and created in the last day.
+type listFilter struct {
+	masks, notMasks []*regexp.Regexp
+
+	// minUsers and maxUsers are -1 when not set
+	minUsers, maxUsers int
+
+	// creation and topic times in milliseconds, 0 when not set
+	createdBefore, createdAfter int64
+	topicBefore, topicAfter     int64
+}
+
+// parseListFilter parses the comma separated ELIST filters of /LIST.
+// Invalid user counts and times are ignored.
+func parseListFilter(param string, now time.Time) *listFilter {
+	f := &listFilter{minUsers: -1, maxUsers: -1}
+
+	// minutesAgo returns the time in milliseconds of s minutes before now
+	minutesAgo := func(s string) (int64, bool) {
+		minutes, err := strconv.Atoi(s)
+		if err != nil || minutes < 0 {
+			return 0, false
+		}
+
+		return now.Add(-time.Duration(minutes)*time.Minute).UnixNano() / int64(time.Millisecond), true
+	}
+
+	for _, token := range strings.Split(param, ",") {
+		token = strings.TrimSpace(token)
+
+		switch {
+		case token == "":
+		case token[0] == '>' || token[0] == '<':
+			n, err := strconv.Atoi(token[1:])
+			if err != nil {
+				continue
+			}
+
+			if token[0] == '>' {
+				f.minUsers = n + 1
+			} else {
+				f.maxUsers = n - 1
+			}
+		case len(token) > 2 && (token[0] == 'C' || token[0] == 'T') && (token[1] == '>' || token[1] == '<'):
+			at, ok := minutesAgo(token[2:])
+			if !ok {
+				continue
+			}
+
+			// C>10: created more than 10 minutes ago, so before that time
+			before, after := &f.createdBefore, &f.createdAfter
+			if token[0] == 'T' {
+				before, after = &f.topicBefore, &f.topicAfter
+			}
+
+			if token[1] == '>' {
+				*before = at
+			} else {
+				*after = at
+			}
+		case token[0] == '!':
+			f.notMasks = append(f.notMasks, maskRegexp(token[1:]))
+		default:
+			f.masks = append(f.masks, maskRegexp(token))
+		}
+	}
+
+	return f
+}
+
+// maskRegexp converts a (case insensitive) channel mask with * and ? wildcards
+// to a regexp, the # prefix is optional.
+func maskRegexp(mask string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(strings.TrimPrefix(mask, "#"))
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+
+	return regexp.MustCompile("(?i)^" + expr + "$")
+}
+
+// match returns true if channel matches the masks and times of the filter.
+func (f *listFilter) match(channel *bridge.ChannelInfo) bool {
+	name := strings.TrimPrefix(channel.Name, "#")
+
+	matched := len(f.masks) == 0
+
+	for _, re := range f.masks {
+		if re.MatchString(name) {
+			matched = true
+			break
+		}
+	}
+
+	for _, re := range f.notMasks {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	return matched &&
+		inRange(channel.CreateAt, f.createdAfter, f.createdBefore) &&
+		inRange(channel.TopicAt, f.topicAfter, f.topicBefore)
+}
+
+// hasUsers returns true if the filter has user counts (>N or <N).
+func (f *listFilter) hasUsers() bool {
+	return f.minUsers >= 0 || f.maxUsers >= 0
+}
+
+// matchUsers returns true if count matches the user count filters.
+func (f *listFilter) matchUsers(count int) bool {
+	return (f.minUsers < 0 || count >= f.minUsers) && (f.maxUsers < 0 || count <= f.maxUsers)
+}
+
+// inRange returns true if after <= at <= before, 0 meaning no limit.
+func inRange(at, after, before int64) bool {
+	return (after == 0 || at >= after) && (before == 0 || at <= before)
+}
+
+// listTopic returns the topic shown by /LIST: the sidebar category, private
+// and archived flags, header and purpose.
+func listTopic(channel *bridge.ChannelInfo) string {
+	var parts []string
+
+	if channel.Category != "" {
+		parts = append(parts, "["+channel.Category+"]")
+	}
+
+	if channel.Private {
+		parts = append(parts, "[private]")
+	}
+
+	if channel.Archived {
+		parts = append(parts, "[archived]")
+	}
+
+	if channel.Topic != "" {
+		parts = append(parts, channel.Topic)
+	}
+
+	if channel.Purpose != "" && channel.Purpose != channel.Topic {
+		if channel.Topic != "" {
+			parts = append(parts, "-")
+		}
+
+		parts = append(parts, channel.Purpose)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// listCountWorkers is the number of member counts /LIST asks for at once.
+const listCountWorkers = 8
+
+// memberCounts returns the member counts of channels, the unknown ones are
+// asked for (listCountWorkers at once).
+func (u *User) memberCounts(channels []*bridge.ChannelInfo) []int {
+	counts := make([]int, len(channels))
+	todo := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < listCountWorkers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range todo {
+				counts[i] = channels[i].Users
+				if counts[i] != 0 {
+					continue
+				}
+
+				count, err := u.br.GetChannelMemberCount(channels[i].ID)
+				if err != nil {
+					logger.Errorf("getting member count of %s failed: %s", channels[i].Name, err)
+				}
+
+				counts[i] = count
+			}
+		}()
+	}
+
+	for i := range channels {
+		todo <- i
+	}
+
+	close(todo)
+	wg.Wait()
+
+	return counts
+}
@@ -0,0 +1,142 @@
+package irckit
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/42wim/matterircd/pkg/matterclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListFilter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	minutesAgo := func(minutes int) int64 {
+		return now.Add(-time.Duration(minutes)*time.Minute).UnixNano() / int64(time.Millisecond)
+	}
+
+	dev := &bridge.ChannelInfo{Name: "#dev", CreateAt: minutesAgo(60 * 24 * 30), TopicAt: minutesAgo(5)}
+	devOld := &bridge.ChannelInfo{Name: "#dev-old", CreateAt: minutesAgo(60 * 24 * 365), TopicAt: minutesAgo(60 * 24 * 365)}
+	teamDev := &bridge.ChannelInfo{Name: "#other/Dev", CreateAt: minutesAgo(10), TopicAt: minutesAgo(10)}
+
+	tests := []struct {
+		Desc     string
+		Filter   string
+		Users    int
+		Expected []*bridge.ChannelInfo
+		UsersOK  bool
+	}{
+		{Desc: "no filter", Filter: "", Users: 0, Expected: []*bridge.ChannelInfo{dev, devOld, teamDev}, UsersOK: true},
+		{Desc: "name", Filter: "#dev", Users: 0, Expected: []*bridge.ChannelInfo{dev}, UsersOK: true},
+		{Desc: "mask without #", Filter: "*dev*", Users: 0, Expected: []*bridge.ChannelInfo{dev, devOld, teamDev}, UsersOK: true},
+		{Desc: "negated mask", Filter: "dev*,!*-old", Users: 0, Expected: []*bridge.ChannelInfo{dev}, UsersOK: true},
+		{Desc: "more users", Filter: ">10", Users: 11, Expected: []*bridge.ChannelInfo{dev, devOld, teamDev}, UsersOK: true},
+		{Desc: "not more users", Filter: ">10", Users: 10, Expected: []*bridge.ChannelInfo{dev, devOld, teamDev}},
+		{Desc: "less users", Filter: "<10", Users: 9, Expected: []*bridge.ChannelInfo{dev, devOld, teamDev}, UsersOK: true},
+		{Desc: "created recently", Filter: "C<60", Users: 0, Expected: []*bridge.ChannelInfo{teamDev}, UsersOK: true},
+		{Desc: "created long ago", Filter: "C>1440", Users: 0, Expected: []*bridge.ChannelInfo{dev, devOld}, UsersOK: true},
+		{Desc: "topic changed recently", Filter: "T<30", Users: 0, Expected: []*bridge.ChannelInfo{dev, teamDev}, UsersOK: true},
+		{Desc: "invalid ignored", Filter: ">x,C<y", Users: 0, Expected: []*bridge.ChannelInfo{dev, devOld, teamDev}, UsersOK: true},
+	}
+
+	for _, tc := range tests {
+		f := parseListFilter(tc.Filter, now)
+
+		var matched []*bridge.ChannelInfo
+
+		for _, channel := range []*bridge.ChannelInfo{dev, devOld, teamDev} {
+			if f.match(channel) {
+				matched = append(matched, channel)
+			}
+		}
+
+		assert.Equal(t, tc.Expected, matched, tc.Desc)
+		assert.Equal(t, tc.UsersOK, f.matchUsers(tc.Users), tc.Desc)
+	}
+
+	assert.False(t, parseListFilter("#dev,C<60", now).hasUsers())
+	assert.True(t, parseListFilter("#dev,>10", now).hasUsers())
+	assert.True(t, parseListFilter("<10", now).hasUsers())
+}
+
+func TestListTopic(t *testing.T) {
+	tests := []struct {
+		Desc     string
+		Channel  *bridge.ChannelInfo
+		Expected string
+	}{
+		{Desc: "empty", Channel: &bridge.ChannelInfo{}, Expected: ""},
+		{Desc: "header", Channel: &bridge.ChannelInfo{Topic: "release friday"}, Expected: "release friday"},
+		{Desc: "purpose", Channel: &bridge.ChannelInfo{Purpose: "dev talk"}, Expected: "dev talk"},
+		{
+			Desc:     "all",
+			Channel:  &bridge.ChannelInfo{Category: "Favorites", Private: true, Archived: true, Topic: "release friday", Purpose: "dev talk"},
+			Expected: "[Favorites] [private] [archived] release friday - dev talk",
+		},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, listTopic(tc.Channel), tc.Desc)
+	}
+}
+
+func TestSortChannelList(t *testing.T) {
+	random := &bridge.ChannelInfo{Name: "#random"}
+	dev := &bridge.ChannelInfo{Name: "#dev", Category: "Work"}
+	ops := &bridge.ChannelInfo{Name: "#ops", Category: "Work"}
+	alerts := &bridge.ChannelInfo{Name: "#alerts", Category: "Monitoring"}
+	town := &bridge.ChannelInfo{Name: "#town-square", Category: matterclient.FavoritesCategory}
+	general := &bridge.ChannelInfo{Name: "#general"}
+
+	channels := []*bridge.ChannelInfo{random, ops, alerts, general, town, dev}
+	sortChannelList(channels)
+
+	assert.Equal(t, []*bridge.ChannelInfo{town, alerts, dev, ops, general, random}, channels)
+}
+
+// countBridge counts the members of channels by their name.
+type countBridge struct {
+	fakeBridge
+
+	sync.Mutex
+	asked []string
+}
+
+func (b *countBridge) GetChannelMemberCount(channelID string) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.asked = append(b.asked, channelID)
+
+	return len(channelID), nil
+}
+
+func TestMemberCounts(t *testing.T) {
+	br := &countBridge{}
+	u := newBridgeUser(br)
+
+	var channels []*bridge.ChannelInfo
+
+	for i := 0; i < 20; i++ {
+		channels = append(channels, &bridge.ChannelInfo{ID: strings.Repeat("c", i+1)})
+	}
+
+	// known counts aren't asked for
+	channels[3].Users = 42
+
+	counts := u.memberCounts(channels)
+
+	for i, count := range counts {
+		if i == 3 {
+			assert.Equal(t, 42, count)
+			continue
+		}
+
+		assert.Equal(t, i+1, count)
+	}
+
+	assert.Len(t, br.asked, 19)
+	assert.NotContains(t, br.asked, channels[3].ID)
+}
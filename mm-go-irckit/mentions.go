@@ -0,0 +1,164 @@
+package irckit
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// nick: or nick, at the start of a message (the IRC way to address someone)
+	addressRe = regexp.MustCompile(`^([^\s:,@]+)([:,])(\s|$)`)
+	// @nick, not @@contextid
+	atNickRe = regexp.MustCompile(`(^|[^\w@])@([^\s@]+)`)
+	// @username as used by mattermost
+	atUsernameRe = regexp.MustCompile(`(^|[^\w@])@([a-zA-Z][a-zA-Z0-9._\-]*)`)
+)
+
+// specialMentions are mattermost mentions that aren't users.
+var specialMentions = map[string]bool{"all": true, "channel": true, "here": true}
+
+// mentionTrailer are characters that end a sentence rather than a nick.
+const mentionTrailer = ".,:;!?)'\""
+
+// nickToMention rewrites the IRC nicks addressed (nick: hi) or mentioned (@nick)
+// in text with mention, text stays as is for unknown nicks.
+func nickToMention(text string, mention func(nick string) (string, bool)) string {
+	prefix := ""
+
+	if m := addressRe.FindStringSubmatch(text); m != nil {
+		if replacement, ok := mention(m[1]); ok {
+			prefix = replacement + m[2]
+			text = text[len(m[1])+len(m[2]):]
+		}
+	}
+
+	return prefix + atNickRe.ReplaceAllStringFunc(text, func(match string) string {
+		m := atNickRe.FindStringSubmatch(match)
+
+		nick := strings.TrimRight(m[2], mentionTrailer)
+		if nick == "" {
+			return match
+		}
+
+		if replacement, ok := mention(nick); ok {
+			return m[1] + replacement + m[2][len(nick):]
+		}
+
+		return match
+	})
+}
+
+// mentionToNick rewrites the @username mentions in text to @nick.
+func mentionToNick(text string, nick func(username string) (string, bool)) string {
+	if !strings.Contains(text, "@") {
+		return text
+	}
+
+	return atUsernameRe.ReplaceAllStringFunc(text, func(match string) string {
+		m := atUsernameRe.FindStringSubmatch(match)
+
+		// usernames may contain dots, but not at the end of a sentence
+		username := m[2]
+		for username != "" && !specialMentions[username] {
+			if n, ok := nick(username); ok {
+				return m[1] + "@" + n + m[2][len(username):]
+			}
+
+			if !strings.HasSuffix(username, ".") {
+				break
+			}
+
+			username = strings.TrimRight(username, ".")
+		}
+
+		return match
+	})
+}
+
+// outgoingMentions rewrites the nicks of ghosts in text to mentions of their
+// mattermost or slack user (NickToMention).
+func (u *User) outgoingMentions(text string) string {
+	if !u.v.GetBool(u.br.Protocol() + ".nicktomention") {
+		return text
+	}
+
+	return nickToMention(text, func(nick string) (string, bool) {
+		ghost, ok := u.Srv.HasUser(nick)
+		if !ok || !ghost.Ghost {
+			return "", false
+		}
+
+		if u.br.Protocol() == "slack" {
+			return "<@" + ghost.User + ">", true
+		}
+
+		if ghost.Username == "" {
+			return "", false
+		}
+
+		return "@" + ghost.Username, true
+	})
+}
+
+// incomingMentions rewrites mentions of users in text to the nicks shown on
+// IRC (MentionToNick).
+func (u *User) incomingMentions(text string) string {
+	if !u.v.GetBool(u.br.Protocol() + ".mentiontonick") {
+		return text
+	}
+
+	if u.br.Protocol() == "slack" {
+		// the slack bridge already rewrote <@U1234> to @nick (mentionName),
+		// only our own nick may differ on IRC
+		var me string
+
+		return mentionToNick(text, func(nick string) (string, bool) {
+			if me == "" {
+				me = u.br.GetMe().Nick
+			}
+
+			if nick == me {
+				return u.Nick, true
+			}
+
+			if ghost, ok := u.Srv.HasUser(sanitizeNick(nick)); ok && ghost.Ghost {
+				return ghost.Nick, true
+			}
+
+			return "", false
+		})
+	}
+
+	var me string
+
+	// only users we know are rewritten, a message never asks the server
+	return mentionToNick(text, func(username string) (string, bool) {
+		// most nicks are usernames
+		if ghost, ok := u.Srv.HasUser(username); ok && ghost.Ghost && ghost.Username == username {
+			return ghost.Nick, true
+		}
+
+		if me == "" {
+			me = u.br.GetMe().Username
+		}
+
+		if username == me {
+			return u.Nick, true
+		}
+
+		info := u.br.GetCachedUserByUsername(username)
+		if info == nil || info.User == "" {
+			return "", false
+		}
+
+		if ghost, ok := u.Srv.HasUserID(info.User); ok {
+			return ghost.Nick, true
+		}
+
+		if info.Nick != "" {
+			return sanitizeNick(info.Nick), true
+		}
+
+		return "", false
+	})
+}
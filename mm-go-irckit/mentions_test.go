@@ -0,0 +1,98 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNickToMention(t *testing.T) {
+	ghosts := map[string]string{"Bob": "@robert", "ann[away]": "@ann", "robert": "@bobby"}
+
+	mention := func(nick string) (string, bool) {
+		m, ok := ghosts[nick]
+		return m, ok
+	}
+
+	tests := []struct {
+		Desc     string
+		Text     string
+		Expected string
+	}{
+		{Desc: "no nicks", Text: "hello world", Expected: "hello world"},
+		{Desc: "address", Text: "Bob: hi", Expected: "@robert: hi"},
+		{Desc: "address with comma", Text: "Bob, hi", Expected: "@robert, hi"},
+		{Desc: "address only", Text: "Bob:", Expected: "@robert:"},
+		{Desc: "address unknown", Text: "Alice: hi", Expected: "Alice: hi"},
+		{Desc: "not rewritten twice", Text: "Bob: ask @robert", Expected: "@robert: ask @bobby"},
+		{Desc: "at nick", Text: "ask @Bob and @ann[away].", Expected: "ask @robert and @ann."},
+		{Desc: "at unknown", Text: "ask @alice", Expected: "ask @alice"},
+		{Desc: "email", Text: "mail me@Bob", Expected: "mail me@Bob"},
+		{Desc: "context id", Text: "@@abc +:thumbsup:", Expected: "@@abc +:thumbsup:"},
+		{Desc: "time", Text: "Bob:10 tomorrow", Expected: "Bob:10 tomorrow"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, nickToMention(tc.Text, mention), tc.Desc)
+	}
+}
+
+func TestMentionToNick(t *testing.T) {
+	nicks := map[string]string{"robert": "Bob", "ann.smith": "ann"}
+
+	nick := func(username string) (string, bool) {
+		n, ok := nicks[username]
+		return n, ok
+	}
+
+	tests := []struct {
+		Desc     string
+		Text     string
+		Expected string
+	}{
+		{Desc: "no mentions", Text: "hello world", Expected: "hello world"},
+		{Desc: "mention", Text: "@robert: hi", Expected: "@Bob: hi"},
+		{Desc: "dotted username", Text: "ping @ann.smith", Expected: "ping @ann"},
+		{Desc: "end of sentence", Text: "thanks @robert.", Expected: "thanks @Bob."},
+		{Desc: "dotted end of sentence", Text: "thanks @ann.smith...", Expected: "thanks @ann..."},
+		{Desc: "special", Text: "@channel hi", Expected: "@channel hi"},
+		{Desc: "unknown", Text: "@alice hi", Expected: "@alice hi"},
+		{Desc: "email", Text: "mail bob@robert.com", Expected: "mail bob@robert.com"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, mentionToNick(tc.Text, nick), tc.Desc)
+	}
+}
+
+// mentionBridge knows the users in users, asking the server (GetUserByUsername)
+// panics.
+type mentionBridge struct {
+	fakeBridge
+
+	users map[string]*bridge.UserInfo
+}
+
+func (b *mentionBridge) GetMe() *bridge.UserInfo {
+	return &bridge.UserInfo{User: "meid", Username: "me.myself"}
+}
+
+func (b *mentionBridge) GetCachedUserByUsername(username string) *bridge.UserInfo {
+	return b.users[username]
+}
+
+func TestIncomingMentions(t *testing.T) {
+	u := newBridgeUser(&mentionBridge{users: map[string]*bridge.UserInfo{
+		"ann.smith": {User: "annid", Username: "ann.smith", Nick: "ann smith"},
+		"robert":    {User: "bobid", Username: "robert", Nick: "robert"},
+	}})
+	u.Nick = "mynick"
+	u.v.Set("mattermost.MentionToNick", true)
+
+	bob := &User{UserInfo: &bridge.UserInfo{Nick: "Bob", User: "bobid", Username: "robert", Ghost: true}, channels: make(map[Channel]struct{})}
+	u.Srv.Add(bob)
+
+	assert.Equal(t, "@mynick: ask @ann-smith and @Bob about @someone-who-left",
+		u.incomingMentions("@me.myself: ask @ann.smith and @robert about @someone-who-left"))
+}
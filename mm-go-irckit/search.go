@@ -0,0 +1,161 @@
+package irckit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// searchPageSize is the number of results SEARCH (and SEARCH more) shows.
+const searchPageSize = 10
+
+// searchQuery is a parsed SEARCH command.
+type searchQuery struct {
+	terms    []string // for mattermost, with nicks and channels translated
+	team     string   // team name, empty for all teams
+	isThread bool     // only posts in threads
+}
+
+// parseSearch parses the SEARCH arguments. Besides the mattermost terms and
+// filters (eg "exact phrase", -word, on:, before: and after: dates) it knows
+//
+//	from:nick     posts of nick (or a mattermost username)
+//	in:#channel   posts in channel, in:#team/channel selects the team too
+//	in:nick       direct messages with nick
+//	is:thread     only root posts with replies and replies
+//	team:name     only search team name
+func parseSearch(args []string, username func(nick string) (string, bool)) *searchQuery {
+	q := &searchQuery{}
+
+	for _, arg := range args {
+		key, value := "", arg
+		if i := strings.Index(arg, ":"); i > 0 {
+			key, value = strings.ToLower(arg[:i]), arg[i+1:]
+		}
+
+		switch {
+		case key == "from" && value != "":
+			if name, ok := username(strings.TrimPrefix(value, "@")); ok {
+				value = name
+			}
+
+			q.terms = append(q.terms, "from:"+strings.TrimPrefix(value, "@"))
+		case key == "in" && strings.HasPrefix(value, "#"):
+			channel := strings.TrimPrefix(value, "#")
+			if i := strings.Index(channel, "/"); i > 0 {
+				if q.team == "" {
+					q.team = channel[:i]
+				}
+
+				channel = channel[i+1:]
+			}
+
+			q.terms = append(q.terms, "in:"+channel)
+		case key == "in" && value != "":
+			if name, ok := username(strings.TrimPrefix(value, "@")); ok {
+				value = "@" + name
+			}
+
+			q.terms = append(q.terms, "in:"+value)
+		case key == "is" && strings.EqualFold(value, "thread"):
+			q.isThread = true
+		case key == "team" && value != "":
+			q.team = value
+		default:
+			q.terms = append(q.terms, arg)
+		}
+	}
+
+	return q
+}
+
+// match returns true if the post p is a result of the query.
+func (q *searchQuery) match(p *model.Post) bool {
+	if p.DeleteAt > p.CreateAt {
+		return false
+	}
+
+	return !q.isThread || p.RootId != "" || p.ReplyCount > 0
+}
+
+// searchNickToUsername returns the mattermost username of the ghost nick.
+func (u *User) searchNickToUsername(nick string) (string, bool) {
+	ghost, ok := u.Srv.HasUser(nick)
+	if !ok || !ghost.Ghost || ghost.Username == "" {
+		return "", false
+	}
+
+	return ghost.Username, true
+}
+
+// formatSearchResult formats the lines of the search result p, tagged with
+// the channel and context ID to reply, react or view the thread, eg
+// #town-square [abc] 2021-06-01 15:04 <nick> can someone review my PR?
+func (u *User) formatSearchResult(p *model.Post) []string {
+	name := u.br.GetChannelName(p.ChannelId)
+	contextID := p.ChannelId
+
+	if info, err := u.br.GetChannel(p.ChannelId); err == nil {
+		contextID = u.contextChannelID(info)
+	}
+
+	if strings.Contains(name, "__") {
+		name = "@" + u.br.GetUser(contextID).Nick
+	}
+
+	nick := u.br.GetUser(p.UserId).Nick
+	if botname, ok := p.GetProps()["override_username"].(string); ok {
+		nick = botname
+	}
+
+	ts := time.Unix(0, p.CreateAt*int64(time.Millisecond)).Format("2006-01-02 15:04")
+	prefix := fmt.Sprintf("%s %s %s <%s> ", name, u.prefixContext(contextID, p.Id, p.ParentId, ""), ts, nick)
+
+	var lines []string
+
+	for _, line := range strings.Split(u.incomingMentions(u.emojiText(p.Message)), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, prefix+line)
+		}
+	}
+
+	if len(p.FileIds) > 0 {
+		for _, fname := range u.br.GetFileLinks(p.FileIds) {
+			lines = append(lines, prefix+"download file - "+fname)
+		}
+	}
+
+	return lines
+}
+
+// showSearchResults shows the next page of results of the last SEARCH.
+func (u *User) showSearchResults(toUser *User) {
+	u.searchMutex.Lock()
+
+	page := u.searchResults
+	if len(page) > searchPageSize {
+		page = page[:searchPageSize]
+	}
+
+	u.searchResults = u.searchResults[len(page):]
+	left := len(u.searchResults)
+
+	u.searchMutex.Unlock()
+
+	if len(page) == 0 {
+		u.MsgUser(toUser, "no more results")
+		return
+	}
+
+	for _, p := range page {
+		for _, line := range u.formatSearchResult(p) {
+			u.MsgUser(toUser, line)
+		}
+	}
+
+	if left > 0 {
+		u.MsgUser(toUser, fmt.Sprintf("%d more results, use SEARCH more to show them", left))
+	}
+}
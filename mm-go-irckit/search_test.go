@@ -0,0 +1,55 @@
+package irckit
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearch(t *testing.T) {
+	ghosts := map[string]string{"Bob": "robert"}
+
+	username := func(nick string) (string, bool) {
+		name, ok := ghosts[nick]
+		return name, ok
+	}
+
+	tests := []struct {
+		Desc     string
+		Args     []string
+		Expected *searchQuery
+	}{
+		{Desc: "terms", Args: []string{"release", "-beta"}, Expected: &searchQuery{terms: []string{"release", "-beta"}}},
+		{Desc: "from nick", Args: []string{"from:Bob", "release"}, Expected: &searchQuery{terms: []string{"from:robert", "release"}}},
+		{Desc: "from username", Args: []string{"from:@alice"}, Expected: &searchQuery{terms: []string{"from:alice"}}},
+		{Desc: "in channel", Args: []string{"in:#dev"}, Expected: &searchQuery{terms: []string{"in:dev"}}},
+		{Desc: "in team channel", Args: []string{"in:#ops/dev"}, Expected: &searchQuery{terms: []string{"in:dev"}, team: "ops"}},
+		{Desc: "in direct message", Args: []string{"in:Bob"}, Expected: &searchQuery{terms: []string{"in:@robert"}}},
+		{Desc: "dates", Args: []string{"after:2021-06-01", "before:2021-07-01"}, Expected: &searchQuery{terms: []string{"after:2021-06-01", "before:2021-07-01"}}},
+		{Desc: "is thread", Args: []string{"IS:Thread", "bug"}, Expected: &searchQuery{terms: []string{"bug"}, isThread: true}},
+		{Desc: "team", Args: []string{"team:ops", "in:#dev"}, Expected: &searchQuery{terms: []string{"in:dev"}, team: "ops"}},
+		{Desc: "explicit team wins", Args: []string{"team:ops", "in:#dev/dev"}, Expected: &searchQuery{terms: []string{"in:dev"}, team: "ops"}},
+		{Desc: "time in terms", Args: []string{"meeting", "10:30"}, Expected: &searchQuery{terms: []string{"meeting", "10:30"}}},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, parseSearch(tc.Args, username), tc.Desc)
+	}
+}
+
+func TestSearchQueryMatch(t *testing.T) {
+	root := &model.Post{CreateAt: 1, ReplyCount: 2}
+	reply := &model.Post{CreateAt: 2, RootId: "root"}
+	single := &model.Post{CreateAt: 3}
+	deleted := &model.Post{CreateAt: 4, DeleteAt: 5}
+
+	all := &searchQuery{}
+	threads := &searchQuery{isThread: true}
+
+	assert.True(t, all.match(single))
+	assert.False(t, all.match(deleted))
+	assert.True(t, threads.match(root))
+	assert.True(t, threads.match(reply))
+	assert.False(t, threads.match(single))
+}
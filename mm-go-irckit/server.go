@@ -58,6 +58,9 @@ type Server interface {
 	// HasChannel returns an existing Channel with a given name.
 	HasChannel(string) (Channel, bool)
 
+	// Channels returns all existing channels.
+	Channels() []Channel
+
 	// UnlinkChannel removes the channel from the server's storage if it
 	// exists. Once removed, the server is free to create a fresh channel with
 	// the same ID. The server is not responsible for evicting members of an
@@ -222,6 +225,26 @@ func (s *server) HasChannel(channelID string) (Channel, bool) {
 	return ch, exists
 }
 
+// Channels returns all existing channels.
+func (s *server) Channels() []Channel {
+	s.RLock()
+	defer s.RUnlock()
+
+	seen := make(map[Channel]bool)
+
+	var channels []Channel
+
+	// channels are stored by ID and by name
+	for _, ch := range s.channels {
+		if !seen[ch] {
+			seen[ch] = true
+			channels = append(channels, ch)
+		}
+	}
+
+	return channels
+}
+
 // Channel returns an existing or new channel with the give name.
 func (s *server) Channel(channelID string) Channel {
 	s.Lock()
@@ -287,6 +310,7 @@ func (s *server) Quit(u *User, message string) {
 	s.Unlock()
 
 	u.br.Logout()
+	u.closeArchive()
 }
 
 // Len returns the number of users connected to the server.
@@ -459,6 +483,10 @@ outerloop:
 			s.u = u
 
 			err := s.welcome(u)
+			if err == nil && u.Pass == nil {
+				u.Pass = configCredentials(u)
+				u.configLogin = u.Pass != nil
+			}
 			if err == nil && u.Pass != nil {
 				service := "mattermost"
 				if len(u.Pass) == 1 {
@@ -485,6 +513,25 @@ outerloop:
 	return ErrHandshakeFailed
 }
 
+// configCredentials returns the login arguments configured in the
+// configuration file (which may be secret references), or nil if none are set
+// or DefaultLogin isn't enabled.
+func configCredentials(u *User) []string {
+	if u.v == nil {
+		return nil
+	}
+
+	if u.v.GetBool("mattermost.DefaultLogin") && u.v.GetString("mattermost.Login") != "" && u.v.GetString("mattermost.Pass") != "" {
+		return []string{u.v.GetString("mattermost.Login"), u.v.GetString("mattermost.Pass")}
+	}
+
+	if u.v.GetBool("slack.DefaultLogin") && u.v.GetString("slack.Token") != "" {
+		return []string{u.v.GetString("slack.Token")}
+	}
+
+	return nil
+}
+
 func (s *server) Logout(user *User) {
 	channels := user.Channels()
 	for _, ch := range channels {
@@ -3,18 +3,26 @@ package irckit
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/42wim/matterircd/bridge"
+	"github.com/42wim/matterircd/pkg/emoji"
+	"github.com/42wim/matterircd/pkg/matterclient"
 	"github.com/sorcix/irc"
 )
 
+// rplWhoisSpecial is used for extra WHOIS information (not in the RFC).
+const rplWhoisSpecial = "320"
+
 func DefaultCommands() Commands {
 	cmds := commands{}
 
 	cmds.Add(Handler{Command: irc.AWAY, Call: CmdAway, LoggedIn: true})
 	cmds.Add(Handler{Command: irc.ISON, Call: CmdIson})
-	cmds.Add(Handler{Command: irc.INVITE, Call: CmdInvite, LoggedIn: true, MinParams: 2})
+	cmds.Add(Handler{Command: irc.INVITE, Call: CmdInvite, LoggedIn: true, MinParams: 1})
 	cmds.Add(Handler{Command: irc.JOIN, Call: CmdJoin, MinParams: 1, LoggedIn: true})
 	cmds.Add(Handler{Command: irc.KICK, Call: CmdKick, MinParams: 1, LoggedIn: true})
 	cmds.Add(Handler{Command: irc.LIST, Call: CmdList, LoggedIn: true})
@@ -37,15 +45,45 @@ func DefaultCommands() Commands {
 func CmdAway(s Server, u *User, msg *irc.Message) error {
 	if msg.Trailing == "" {
 		u.br.SetStatus("online")
+
+		// remove the custom status we've set when going away
+		if u.awayStatus {
+			u.br.SetCustomStatus("", "", 0)
+			u.awayStatus = false
+		}
+
 		return s.EncodeMessage(u, irc.RPL_UNAWAY, []string{u.Nick}, "You are no longer marked as being away")
 	}
 
 	u.br.SetStatus("away")
+
+	if u.v.GetBool(u.br.Protocol() + ".awaycustomstatus") {
+		emoji, text := parseCustomStatus(msg.Trailing)
+		if err := u.br.SetCustomStatus(emoji, text, 0); err != nil {
+			logger.Errorf("setting custom status failed: %s", err)
+		} else {
+			u.awayStatus = true
+		}
+	}
+
 	return s.EncodeMessage(u, irc.RPL_NOWAWAY, []string{u.Nick}, "You have been marked as being away")
 }
 
 func CmdInvite(s Server, u *User, msg *irc.Message) error {
 	who := msg.Params[0]
+
+	// INVITE nick1,nick2 creates a group message channel
+	if len(msg.Params) == 1 {
+		name, err := u.createGroup(strings.Split(who, ","))
+		if err != nil {
+			return s.EncodeMessage(u, irc.ERR_NOSUCHNICK, []string{u.Nick, who}, "Cannot create group: "+err.Error())
+		}
+
+		logger.Debugf("created group %s with %s", name, who)
+
+		return nil
+	}
+
 	channel := msg.Params[1]
 	other, ok := s.HasUser(who)
 	if !ok {
@@ -109,13 +147,28 @@ func CmdKick(s Server, u *User, msg *irc.Message) error {
 func CmdJoin(s Server, u *User, msg *irc.Message) error {
 	var sync func(string, string)
 
+	// JOIN #newchannel create (or private) creates the channel if it doesn't exist
+	var keys []string
+	if len(msg.Params) > 1 {
+		keys = strings.Split(msg.Params[1], ",")
+	}
+
 	channels := strings.Split(msg.Params[0], ",")
-	for _, channel := range channels {
+	for i, channel := range channels {
 		channelName := strings.Replace(channel, "#", "", 1)
-		// you can only join existing channels
+		// you can only join existing channels, unless you create them
 		var err error
 
 		channelID, topic, err := u.br.Join(channelName)
+		if err != nil && i < len(keys) && (keys[i] == "create" || keys[i] == "private") {
+			channelID, err = u.br.CreateChannel(channelName, keys[i] == "private")
+			if err != nil {
+				logger.Errorf("Cannot create channel %s, err: %v", channelName, err)
+				s.EncodeMessage(u, irc.ERR_NOSUCHCHANNEL, []string{u.Nick, channel}, "Cannot create channel: "+err.Error())
+				continue
+			}
+		}
+
 		if err != nil {
 			logger.Errorf("Cannot join channel %s, id %s, err: %v", channelName, channelID, err)
 			s.EncodeMessage(u, irc.ERR_INVITEONLYCHAN, []string{u.Nick, channel}, "Cannot join channel (+i)")
@@ -144,6 +197,10 @@ func CmdJoin(s Server, u *User, msg *irc.Message) error {
 		sync(channelID, channelName)
 
 		ch.Join(u)
+
+		if u.br.IsArchived(channelID) {
+			u.showArchived(channelID)
+		}
 	}
 
 	return nil
@@ -151,35 +208,95 @@ func CmdJoin(s Server, u *User, msg *irc.Message) error {
 
 // CmdList is a handler for the /LIST command.
 func CmdList(s Server, u *User, msg *irc.Message) error {
-	r := []*irc.Message{}
-	r = append(r, &irc.Message{
-		Prefix:   s.Prefix(),
-		Command:  irc.RPL_LISTSTART,
-		Params:   []string{u.Nick},
-		Trailing: "Channel Users Topic",
-	})
+	filter := &listFilter{minUsers: -1, maxUsers: -1}
+	if len(msg.Params) > 0 {
+		filter = parseListFilter(msg.Params[0], time.Now())
+	}
 
-	info, err := u.br.List()
+	channels, err := u.br.List()
 	if err != nil {
 		return err
 	}
 
-	for channelName, topic := range info {
-		r = append(r, &irc.Message{
-			Prefix:   s.Prefix(),
-			Command:  irc.RPL_LIST,
-			Params:   []string{u.Nick, channelName, "0", topic},
-			Trailing: "",
-		})
+	sortChannelList(channels)
+
+	err = s.EncodeMessage(u, irc.RPL_LISTSTART, []string{u.Nick}, "Channel Users Topic")
+	if err != nil {
+		return err
 	}
 
-	r = append(r, &irc.Message{
-		Prefix:   s.Prefix(),
-		Params:   []string{u.Nick},
-		Command:  irc.RPL_LISTEND, // nolint:misspell
-		Trailing: "End of /LIST",
+	var matched []*bridge.ChannelInfo
+
+	for _, channel := range channels {
+		if filter.match(channel) {
+			matched = append(matched, channel)
+		}
+	}
+
+	// without user count filters show the counts we know (0 if unknown)
+	if !filter.hasUsers() {
+		return listChannels(s, u, matched, nil, filter)
+	}
+
+	// counting the members asks the server for every channel without a known
+	// count, which takes a while on large servers. Don't block the connection.
+	go func() {
+		if err := listChannels(s, u, matched, u.memberCounts(matched), filter); err != nil {
+			logger.Errorf("listing channels failed: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+// listChannels sends channels with their member counts (their known counts if
+// counts is nil) matching filter, and the end of the list.
+func listChannels(s Server, u *User, channels []*bridge.ChannelInfo, counts []int, filter *listFilter) error {
+	for i, channel := range channels {
+		count := channel.Users
+		if counts != nil {
+			count = counts[i]
+		}
+
+		if !filter.matchUsers(count) {
+			continue
+		}
+
+		err := s.EncodeMessage(u, irc.RPL_LIST, []string{u.Nick, channel.Name, strconv.Itoa(count)}, listTopic(channel))
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.EncodeMessage(u, irc.RPL_LISTEND, []string{u.Nick}, "End of /LIST") // nolint:misspell
+}
+
+// sortChannelList sorts channels by sidebar category (favorites first and
+// channels without category last) and name.
+func sortChannelList(channels []*bridge.ChannelInfo) {
+	rank := func(category string) int {
+		switch category {
+		case "":
+			return 2
+		case matterclient.FavoritesCategory:
+			return 0
+		}
+
+		return 1
+	}
+
+	sort.SliceStable(channels, func(i, j int) bool {
+		ci, cj := channels[i].Category, channels[j].Category
+		if rank(ci) != rank(cj) {
+			return rank(ci) < rank(cj)
+		}
+
+		if ci != cj {
+			return ci < cj
+		}
+
+		return channels[i].Name < channels[j].Name
 	})
-	return u.Encode(r...)
 }
 
 // CmdLusers is a handler for the /LUSERS command.
@@ -302,6 +419,22 @@ func CmdPart(s Server, u *User, msg *irc.Message) error {
 		}
 		// first part on irc
 		ch.Part(u, msg.Trailing)
+
+		// parting a thread channel unfollows the thread
+		if channelID, rootID, ok := bridge.ParseThreadChannelID(ch.ID()); ok {
+			if err := u.br.FollowThread(channelID, rootID, false); err != nil {
+				logger.Errorf("unfollowing thread %s failed: %s", rootID, err)
+			}
+
+			u.unfollowThread(rootID)
+
+			for _, k := range ch.Users() {
+				ch.Part(k, "")
+			}
+
+			continue
+		}
+
 		// now part on mattermost/slack
 		if !u.v.GetBool(u.br.Protocol() + ".PartFake") {
 			err = u.br.Part(ch.ID())
@@ -369,14 +502,34 @@ func CmdPrivMsg(s Server, u *User, msg *irc.Message) error {
 
 	// are we sending to a channel
 	if ch, exists := s.HasChannel(query); exists {
-		if ch.ID() == "&messages" || ch.ID() == "&users" {
+		if ch.ID() == "&messages" || ch.ID() == "&users" || ch.ID() == "&threads" {
 			return nil
 		}
 
+		// archived channels (and their threads) are read-only
+		channelID := ch.ID()
+		if parentID, _, ok := bridge.ParseThreadChannelID(channelID); ok {
+			channelID = parentID
+		}
+
+		if u.br.IsArchived(channelID) {
+			return s.EncodeMessage(u, irc.ERR_CANNOTSENDTOCHAN, []string{u.Nick, query}, "Cannot send to channel (archived)")
+		}
+
+		msg.Trailing = u.outgoingMentions(msg.Trailing)
+
+		if channelID, rootID, ok := bridge.ParseThreadChannelID(ch.ID()); ok {
+			return threadChannelMsg(u, msg, channelID, rootID)
+		}
+
 		if parseReactionToMsg(u, msg, ch.ID()) {
 			return nil
 		}
 
+		if parseCommandMsg(u, msg, ch.ID()) {
+			return nil
+		}
+
 		if threadMsgChannel(u, msg, ch.ID()) {
 			return nil
 		}
@@ -416,6 +569,13 @@ func CmdPrivMsg(s Server, u *User, msg *irc.Message) error {
 				return nil
 			}
 
+			if strings.HasPrefix(msg.Trailing, "\x01DCC SEND ") {
+				go u.handleDCCSend(toUser, msg.Trailing)
+				return nil
+			}
+
+			msg.Trailing = u.outgoingMentions(msg.Trailing)
+
 			if parseReactionToMsg(u, msg, toUser.User) {
 				return nil
 			}
@@ -451,16 +611,41 @@ func CmdPrivMsg(s Server, u *User, msg *irc.Message) error {
 	return s.EncodeMessage(u, irc.ERR_NOSUCHNICK, msg.Params, "No such nick/channel")
 }
 
+// parseCommandMsg executes slash commands sent as "!cmd /command args" in channelID.
+func parseCommandMsg(u *User, msg *irc.Message, channelID string) bool {
+	if !strings.HasPrefix(msg.Trailing, "!cmd /") {
+		return false
+	}
+
+	command := strings.TrimSpace(strings.TrimPrefix(msg.Trailing, "!cmd "))
+
+	if err := u.br.ExecuteCommand(channelID, command); err != nil {
+		u.MsgSpoofUser(u, u.br.Protocol(), "command: "+command+" failed: "+err.Error())
+	}
+
+	return true
+}
+
 func parseReactionToMsg(u *User, msg *irc.Message, channelID string) bool {
-	re := regexp.MustCompile(`^\@\@([0-9a-f]{3}|[0-9a-z]{26})\s+([\-\+]):(\S+):\s*$`)
+	re := regexp.MustCompile(`^\@\@([0-9a-f]{3}|[0-9a-z]{26})\s+([\-\+])(?::(\S+):|(\S+))\s*$`)
 	matches := re.FindStringSubmatch(msg.Trailing)
-	if len(matches) != 4 {
+	if len(matches) != 5 {
 		return false
 	}
 
 	msgID := matches[1]
 	action := matches[2]
-	emoji := matches[3]
+	reaction := matches[3]
+
+	// unicode emoji are reacted with their shortcode
+	if reaction == "" {
+		name, ok := emoji.Name(matches[4])
+		if !ok {
+			return false
+		}
+
+		reaction = name
+	}
 
 	// matterircd style prefix/suffix contexts (e.g. 001 and fa2).
 	if len(msgID) == 3 {
@@ -483,17 +668,17 @@ func parseReactionToMsg(u *User, msg *irc.Message, channelID string) bool {
 	}
 
 	if action == "-" {
-		err := u.br.RemoveReaction(msgID, emoji)
+		err := u.br.RemoveReaction(msgID, reaction)
 		if err != nil {
-			u.MsgSpoofUser(u, u.br.Protocol(), "reaction: "+emoji+" could not be removed"+err.Error())
+			u.MsgSpoofUser(u, u.br.Protocol(), "reaction: "+reaction+" could not be removed"+err.Error())
 		}
 
 		return true
 	}
 
-	err := u.br.AddReaction(msgID, emoji)
+	err := u.br.AddReaction(msgID, reaction)
 	if err != nil {
-		u.MsgSpoofUser(u, u.br.Protocol(), "reaction: "+emoji+" could not be added"+err.Error())
+		u.MsgSpoofUser(u, u.br.Protocol(), "reaction: "+reaction+" could not be added"+err.Error())
 	}
 
 	return true
@@ -668,6 +853,35 @@ func threadMsgChannelUser(u *User, msg *irc.Message, channelID string, toUser bo
 	return true
 }
 
+// threadChannelMsg sends a message in a thread channel as a reply to rootID.
+// Context IDs, reactions and modifications use the context of channelID.
+func threadChannelMsg(u *User, msg *irc.Message, channelID, rootID string) error {
+	if parseReactionToMsg(u, msg, channelID) {
+		return nil
+	}
+
+	if parseModifyMsg(u, msg, channelID) {
+		return nil
+	}
+
+	msgID, err := u.br.MsgChannelThread(channelID, rootID, msg.Trailing)
+	if err != nil {
+		u.MsgSpoofUser(u, u.br.Protocol(), "msg: "+msg.Trailing+" could not be send"+err.Error())
+		return err
+	}
+
+	u.msgLastMutex.Lock()
+	defer u.msgLastMutex.Unlock()
+	u.msgLast[channelID] = [2]string{msgID, rootID}
+	u.saveLastViewedAt(channelID)
+
+	if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+		u.prefixContext(channelID, msgID, rootID, "")
+	}
+
+	return nil
+}
+
 func threadMsgChannel(u *User, msg *irc.Message, channelID string) bool {
 	return threadMsgChannelUser(u, msg, channelID, false)
 }
@@ -688,6 +902,7 @@ func CmdQuit(s Server, u *User, msg *irc.Message) error {
 		u.br.Logout()
 	}
 	u.Srv.Logout(u)
+	u.closeArchive()
 
 	u.Conn.Close()
 
@@ -776,6 +991,60 @@ func CmdWho(s Server, u *User, msg *irc.Message) error {
 	return u.Encode(r...)
 }
 
+// whoisProfile returns the WHOIS lines of the profile p, with the local time
+// of the user at now.
+func whoisProfile(p *bridge.UserProfile, now time.Time) []string {
+	if p == nil {
+		return nil
+	}
+
+	var lines []string
+
+	if p.DisplayName != "" {
+		lines = append(lines, "display name: "+p.DisplayName)
+	}
+
+	if p.Position != "" {
+		lines = append(lines, "position: "+p.Position)
+	}
+
+	if p.Email != "" {
+		lines = append(lines, "email: "+p.Email)
+	}
+
+	if p.Timezone != "" {
+		timezone := "timezone: " + p.Timezone
+		if loc, err := time.LoadLocation(p.Timezone); err == nil {
+			timezone += " (local time " + now.In(loc).Format("15:04") + ")"
+		}
+
+		lines = append(lines, timezone)
+	}
+
+	if p.LastActivity > 0 {
+		lastActivity := time.Unix(0, p.LastActivity*int64(time.Millisecond)).In(now.Location())
+		lines = append(lines, "last activity: "+lastActivity.Format("2006-01-02 15:04"))
+	}
+
+	if p.Bot {
+		lines = append(lines, "is a bot")
+	}
+
+	if p.Guest {
+		lines = append(lines, "is a guest")
+	}
+
+	if p.Deactivated {
+		lines = append(lines, "is deactivated")
+	}
+
+	if len(p.Teams) > 0 {
+		lines = append(lines, "teams: "+strings.Join(p.Teams, " "))
+	}
+
+	return lines
+}
+
 // CmdWhois is a handler for the /WHOIS command.
 func CmdWhois(s Server, u *User, msg *irc.Message) error {
 	who := msg.Params[0]
@@ -802,6 +1071,9 @@ func CmdWhois(s Server, u *User, msg *irc.Message) error {
 		})
 
 		status, _ := u.br.StatusUser(other.User)
+		if status == "dnd" {
+			status = "dnd (do not disturb)"
+		}
 
 		if status != "online" {
 			r = append(r, &irc.Message{
@@ -812,6 +1084,31 @@ func CmdWhois(s Server, u *User, msg *irc.Message) error {
 			})
 		}
 
+		if custom := u.br.GetCustomStatus(other.User); custom != "" {
+			r = append(r, &irc.Message{
+				Prefix:   s.Prefix(),
+				Params:   []string{u.Nick, other.Nick},
+				Command:  rplWhoisSpecial,
+				Trailing: "custom status: " + custom,
+			})
+		}
+
+		if other.Ghost {
+			profile, err := u.br.GetUserProfile(other.User)
+			if err != nil {
+				logger.Debugf("getting profile of %s failed: %s", other.Nick, err)
+			}
+
+			for _, line := range whoisProfile(profile, time.Now()) {
+				r = append(r, &irc.Message{
+					Prefix:   s.Prefix(),
+					Params:   []string{u.Nick, other.Nick},
+					Command:  rplWhoisSpecial,
+					Trailing: line,
+				})
+			}
+		}
+
 		r = append(r, &irc.Message{
 			Prefix:   s.Prefix(),
 			Params:   []string{u.Nick, other.Nick},
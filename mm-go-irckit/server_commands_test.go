@@ -0,0 +1,52 @@
+package irckit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhoisProfile(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		Desc     string
+		Profile  *bridge.UserProfile
+		Expected []string
+	}{
+		{Desc: "no profile"},
+		{Desc: "empty profile", Profile: &bridge.UserProfile{}},
+		{
+			Desc: "full profile",
+			Profile: &bridge.UserProfile{
+				DisplayName:  "Bob Smith",
+				Position:     "developer",
+				Email:        "bob@example.com",
+				Timezone:     "Asia/Tokyo",
+				LastActivity: now.Add(-time.Hour).UnixNano() / int64(time.Millisecond),
+				Guest:        true,
+				Teams:        []string{"dev", "ops"},
+			},
+			Expected: []string{
+				"display name: Bob Smith",
+				"position: developer",
+				"email: bob@example.com",
+				"timezone: Asia/Tokyo (local time 21:00)",
+				"last activity: 2021-06-01 11:00",
+				"is a guest",
+				"teams: dev ops",
+			},
+		},
+		{
+			Desc:     "unknown timezone",
+			Profile:  &bridge.UserProfile{Timezone: "Mars/Olympus", Bot: true, Deactivated: true},
+			Expected: []string{"timezone: Mars/Olympus", "is a bot", "is deactivated"},
+		},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, whoisProfile(tc.Profile, now), tc.Desc)
+	}
+}
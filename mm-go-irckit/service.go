@@ -10,6 +10,7 @@ import (
 	"unicode"
 
 	"github.com/42wim/matterircd/bridge"
+	"github.com/42wim/matterircd/pkg/matterclient"
 	"github.com/mattermost/mattermost-server/v5/model"
 )
 
@@ -40,6 +41,11 @@ func login(u *User, toUser *User, args []string, service string) {
 		return
 	}
 
+	// only the automatic login with the configured credentials may use
+	// secret references, see configCredentials.
+	fromConfig := u.configLogin
+	u.configLogin = false
+
 	if service == "slack" {
 		var err error
 
@@ -49,7 +55,7 @@ func login(u *User, toUser *User, args []string, service string) {
 		}
 
 		if len(args) == 1 {
-			u.Credentials.Token = args[len(args)-1]
+			u.Credentials = bridge.Credentials{Token: args[len(args)-1]}
 		}
 
 		if u.Credentials.Token == "help" {
@@ -74,6 +80,8 @@ func login(u *User, toUser *User, args []string, service string) {
 			}
 		}
 
+		u.Credentials.FromConfig = fromConfig
+
 		if u.br != nil && u.br.Connected() {
 			err = u.br.Logout()
 			if err != nil {
@@ -172,6 +180,8 @@ func login(u *User, toUser *User, args []string, service string) {
 		return
 	}
 
+	cred.FromConfig = fromConfig
+
 	if !u.isValidServer(cred.Server, service) {
 		u.MsgUser(toUser, "not allowed to connect to "+cred.Server)
 		return
@@ -185,9 +195,23 @@ func login(u *User, toUser *User, args []string, service string) {
 		}
 	}
 
+	u.loginToMattermost(toUser, cred)
+}
+
+// loginToMattermost logs in with cred, if mattermost asks for a MFA token we
+// keep the credentials and ask the user to send it.
+func (u *User) loginToMattermost(toUser *User, cred bridge.Credentials) {
+	u.pendingMFA = nil
 	u.Credentials = cred
 
 	err := u.loginTo("mattermost")
+	if errors.Is(err, matterclient.ErrMFARequired) {
+		u.pendingMFA = &cred
+		u.MsgUser(toUser, err.Error())
+		u.MsgUser(toUser, "send your MFA code (or MFA <code>) to continue the login")
+		return
+	}
+
 	if err != nil {
 		u.MsgUser(toUser, err.Error())
 		return
@@ -196,47 +220,107 @@ func login(u *User, toUser *User, args []string, service string) {
 	u.MsgUser(toUser, "login OK")
 }
 
-func search(u *User, toUser *User, args []string, service string) {
+func mfa(u *User, toUser *User, args []string, service string) {
 	if service == "slack" {
 		u.MsgUser(toUser, "not implemented")
 		return
 	}
 
-	list := u.br.SearchPosts(strings.Join(args, " "))
-	if list == nil || list.(*model.PostList) == nil || len(list.(*model.PostList).Order) == 0 {
-		u.MsgUser(toUser, "no results")
+	// continue a login waiting for a MFA token
+	if u.pendingMFA != nil {
+		cred := *u.pendingMFA
+		cred.MFAToken = args[0]
+		u.loginToMattermost(toUser, cred)
 		return
 	}
 
-	postlist := list.(*model.PostList)
+	if u.br == nil {
+		u.MsgUser(toUser, "You're not logged in. Use LOGIN first.")
+		return
+	}
 
-	for i := len(postlist.Order) - 1; i >= 0; i-- {
-		if postlist.Posts[postlist.Order[i]].DeleteAt > postlist.Posts[postlist.Order[i]].CreateAt {
-			continue
-		}
+	if err := u.br.SetMFAToken(args[0]); err != nil {
+		u.MsgUser(toUser, fmt.Sprintf("setting MFA token failed: %s", err))
+		return
+	}
 
-		timestamp := time.Unix(postlist.Posts[postlist.Order[i]].CreateAt/1000, 0).Format("January 02, 2006 15:04")
-		channelname := u.br.GetChannelName(postlist.Posts[postlist.Order[i]].ChannelId)
+	u.MsgUser(toUser, "MFA token set, reconnecting")
+}
 
-		nick := u.br.GetUser(postlist.Posts[postlist.Order[i]].UserId).Nick
+func search(u *User, toUser *User, args []string, service string) {
+	if service == "slack" {
+		u.MsgUser(toUser, "not implemented")
+		return
+	}
 
-		u.MsgUser(toUser, "#"+channelname+" <"+nick+"> "+timestamp)
-		u.MsgUser(toUser, strings.Repeat("=", len("#"+channelname+" <"+nick+"> "+timestamp)))
+	if len(args) == 1 && strings.EqualFold(args[0], "more") {
+		u.showSearchResults(toUser)
+		return
+	}
 
-		for _, post := range strings.Split(postlist.Posts[postlist.Order[i]].Message, "\n") {
-			if post != "" {
-				u.MsgUser(toUser, post)
-			}
-		}
+	q := parseSearch(args, u.searchNickToUsername)
+	if len(q.terms) == 0 {
+		u.MsgUser(toUser, "need SEARCH <terms> [from:<nick>] [in:#<channel>|<nick>] [before:|after:|on:<yyyy-mm-dd>] [is:thread] [team:<team>]")
+		u.MsgUser(toUser, "e.g. SEARCH release from:bob in:#dev after:2021-06-01, SEARCH more shows more results")
+		return
+	}
+
+	list := u.br.SearchPosts(strings.Join(q.terms, " "), q.team)
+
+	var results []*model.Post
 
-		if len(postlist.Posts[postlist.Order[i]].FileIds) > 0 {
-			for _, fname := range u.br.GetFileLinks(postlist.Posts[postlist.Order[i]].FileIds) {
-				u.MsgUser(toUser, "download file - "+fname)
+	if postlist, ok := list.(*model.PostList); ok && postlist != nil {
+		for _, id := range postlist.Order {
+			if p := postlist.Posts[id]; q.match(p) {
+				results = append(results, p)
 			}
 		}
+	}
 
-		u.MsgUser(toUser, "")
-		u.MsgUser(toUser, "")
+	if len(results) == 0 {
+		u.MsgUser(toUser, "no results")
+		return
+	}
+
+	u.searchMutex.Lock()
+	u.searchResults = results
+	u.searchMutex.Unlock()
+
+	u.MsgUser(toUser, fmt.Sprintf("%d results, newest first", len(results)))
+	u.showSearchResults(toUser)
+}
+
+func localSearch(u *User, toUser *User, args []string, service string) {
+	path := u.archivePath()
+	if path == "" {
+		u.MsgUser(toUser, "no local archive, enable Archive and StateDir in the config")
+		return
+	}
+
+	filter, err := parseArchiveFilter(args, time.Local)
+	if err != nil {
+		u.MsgUser(toUser, err.Error())
+		u.MsgUser(toUser, "need LOCALSEARCH <words> [from:<nick>] [in:#<channel>|<nick>] [before:|after:|on:<yyyy-mm-dd>] [is:edited|deleted|reaction]")
+		return
+	}
+
+	matches, total, err := searchArchiveFile(path, filter)
+	if err != nil {
+		u.MsgUser(toUser, "local search failed: "+err.Error())
+		return
+	}
+
+	if total == 0 {
+		u.MsgUser(toUser, "no results")
+		return
+	}
+
+	for _, rec := range matches {
+		u.MsgUser(toUser, formatArchiveRecord(rec, time.Local))
+	}
+
+	if total > len(matches) {
+		u.MsgUser(toUser, fmt.Sprintf("showed the newest %d of %d results", len(matches), total))
 	}
 }
 
@@ -276,15 +360,15 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 		return
 	}
 
-	var channelID string
-	var spoof func(string, string)
+	var channelID, contextID string
 	scrollbackUser, exists := u.Srv.HasUser(args[0])
 
 	switch {
 	case strings.HasPrefix(args[0], "#"):
 		channelName := strings.ReplaceAll(args[0], "#", "")
 		channelID = u.br.GetChannelID(channelName, u.br.GetMe().TeamID)
-		spoof = u.Srv.Channel(channelID).SpoofMessage
+		contextID = channelID
+		scrollbackUser = nil
 	case exists && scrollbackUser.Ghost:
 		// We need to sort the two user IDs to construct the DM
 		// channel name.
@@ -292,6 +376,7 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 		sort.Strings(userIDs)
 		channelName := userIDs[0] + "__" + userIDs[1]
 		channelID = u.br.GetChannelID(channelName, u.br.GetMe().TeamID)
+		contextID = scrollbackUser.User
 	default:
 		u.MsgUser(toUser, "need SCROLLBACK (#<channel>|<user>) <lines>")
 		u.MsgUser(toUser, "e.g. SCROLLBACK #bugs 10 (show last 10 lines from #bugs)")
@@ -306,9 +391,46 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 
 	postlist := list.(*model.PostList)
 
+	var posts []*model.Post
+
 	for i := len(postlist.Order) - 1; i >= 0; i-- {
-		p := postlist.Posts[postlist.Order[i]]
-		ts := time.Unix(0, p.CreateAt*int64(time.Millisecond))
+		posts = append(posts, postlist.Posts[postlist.Order[i]])
+	}
+
+	u.showPosts(posts, channelID, contextID, scrollbackUser)
+}
+
+// showPosts shows posts with their timestamp (and context ID) in the channel
+// channelID, or as messages from dmUser for direct messages.
+func (u *User) showPosts(posts []*model.Post, channelID, contextID string, dmUser *User) {
+	show := func(nick, text string) {
+		u.Srv.Channel(channelID).SpoofMessage(nick, text)
+	}
+
+	if dmUser != nil {
+		show = func(nick, text string) {
+			u.MsgSpoofUser(dmUser, nick, text)
+		}
+	}
+
+	format := func(p *model.Post, nick, ts, text string) string {
+		if u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost" {
+			return u.formatContextMessage(ts, u.prefixContext(contextID, p.Id, p.ParentId, ""), text)
+		}
+
+		if dmUser != nil {
+			text = "<" + nick + "> " + text
+		}
+
+		if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+			return u.formatContextMessage(ts, u.prefixContext(contextID, p.Id, p.ParentId, ""), text)
+		}
+
+		return "[" + ts + "] " + text
+	}
+
+	for _, p := range posts {
+		ts := time.Unix(0, p.CreateAt*int64(time.Millisecond)).Format("2006-01-02 15:04")
 
 		props := p.GetProps()
 		botname, override := props["override_username"].(string)
@@ -319,7 +441,7 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 		}
 
 		codeBlock := false
-		for _, post := range strings.Split(p.Message, "\n") {
+		for _, post := range strings.Split(u.incomingMentions(u.emojiText(p.Message)), "\n") {
 			if post == "```" {
 				codeBlock = !codeBlock
 			}
@@ -328,22 +450,7 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 				continue
 			}
 
-			switch { // nolint:dupl
-			case u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost" && strings.HasPrefix(args[0], "#"):
-				threadMsgID := u.prefixContext("", p.Id, p.ParentId, "")
-				scrollbackMsg := u.formatContextMessage(ts.Format("2006-01-02 15:04"), threadMsgID, post)
-				spoof(nick, scrollbackMsg)
-			case u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost":
-				threadMsgID := u.prefixContext("", p.Id, p.ParentId, "")
-				scrollbackMsg := u.formatContextMessage(ts.Format("2006-01-02 15:04"), threadMsgID, post)
-				u.MsgSpoofUser(scrollbackUser, nick, scrollbackMsg)
-			case strings.HasPrefix(args[0], "#"):
-				scrollbackMsg := "[" + ts.Format("2006-01-02 15:04") + "] " + post
-				spoof(nick, scrollbackMsg)
-			default:
-				scrollbackMsg := "[" + ts.Format("2006-01-02 15:04") + "]" + " <" + nick + "> " + post
-				u.MsgSpoofUser(scrollbackUser, nick, scrollbackMsg)
-			}
+			show(nick, format(p, nick, ts, post))
 		}
 
 		if len(p.FileIds) == 0 {
@@ -351,25 +458,212 @@ func scrollback(u *User, toUser *User, args []string, service string) {
 		}
 
 		for _, fname := range u.br.GetFileLinks(p.FileIds) {
-			fileMsg := "download file - " + fname
-			switch { // nolint:dupl
-			case u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost" && strings.HasPrefix(args[0], "#"):
-				threadMsgID := u.prefixContext("", p.Id, p.ParentId, "")
-				scrollbackMsg := u.formatContextMessage(ts.Format("2006-01-02 15:04"), threadMsgID, fileMsg)
-				spoof(nick, scrollbackMsg)
-			case u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost":
-				threadMsgID := u.prefixContext("", p.Id, p.ParentId, "")
-				scrollbackMsg := u.formatContextMessage(ts.Format("2006-01-02 15:04"), threadMsgID, fileMsg)
-				u.MsgSpoofUser(scrollbackUser, nick, scrollbackMsg)
-			case strings.HasPrefix(args[0], "#"):
-				scrollbackMsg := "[" + ts.Format("2006-01-02 15:04") + "] " + fileMsg
-				spoof(nick, scrollbackMsg)
-			default:
-				scrollbackMsg := "[" + ts.Format("2006-01-02 15:04") + "]" + " <" + nick + "> " + fileMsg
-				u.MsgSpoofUser(scrollbackUser, nick, scrollbackMsg)
-			}
+			show(nick, format(p, nick, ts, "download file - "+fname))
+		}
+	}
+}
+
+func thread(u *User, toUser *User, args []string, service string) {
+	if service == "slack" {
+		u.MsgUser(toUser, "not implemented")
+		return
+	}
+
+	usage := func() {
+		u.MsgUser(toUser, "need THREAD [#<channel>|<user>] <@@postid|contextid>")
+		u.MsgUser(toUser, "e.g. THREAD #bugs abc or THREAD @@cfrakpwix7y8pgzux6ta76pm9c")
+	}
+
+	if len(args) == 0 || len(args) > 2 {
+		usage()
+		return
+	}
+
+	target := ""
+	if len(args) == 2 {
+		target = args[0]
+	}
+
+	postID, ambiguous := u.resolvePostID(target, args[len(args)-1])
+	if ambiguous {
+		u.MsgUser(toUser, "context ID "+args[0]+" is used in multiple channels, use THREAD <#channel|user> "+args[0])
+		return
+	}
+
+	if len(postID) != 26 {
+		u.MsgUser(toUser, "thread "+args[len(args)-1]+" not found")
+		return
+	}
+
+	list := u.br.GetPostThread(postID)
+	if list == nil || list.(*model.PostList) == nil || len(list.(*model.PostList).Posts) == 0 {
+		u.MsgUser(toUser, "thread "+args[len(args)-1]+" not found")
+		return
+	}
+
+	postlist := list.(*model.PostList)
+
+	var posts []*model.Post
+
+	for _, p := range postlist.Posts {
+		posts = append(posts, p)
+	}
+
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].CreateAt < posts[j].CreateAt
+	})
+
+	channelID := posts[0].ChannelId
+
+	info, err := u.br.GetChannel(channelID)
+	if err != nil {
+		u.MsgUser(toUser, "channel of thread "+args[len(args)-1]+" not found")
+		return
+	}
+
+	contextID := u.contextChannelID(info)
+
+	// direct messages are shown as messages from the other user
+	var dmUser *User
+
+	if contextID != channelID {
+		if dmUser, _ = u.Srv.HasUserID(contextID); dmUser == nil {
+			u.MsgUser(toUser, "user of thread "+args[len(args)-1]+" not found")
+			return
 		}
 	}
+
+	u.showPosts(posts, channelID, contextID, dmUser)
+}
+
+// resolvePostID returns the post ID of @@postid, or of context ID id in target
+// (#channel or user). Without target the context ID is looked up in all
+// channels and users, ambiguous is true when it's used in more than one.
+func (u *User) resolvePostID(target, id string) (postID string, ambiguous bool) {
+	if target != "" || !isContextID(id) {
+		return u.targetPostID(target, id), false
+	}
+
+	postIDs := u.contextPostIDs(id)
+
+	switch len(postIDs) {
+	case 0:
+		return "", false
+	case 1:
+		return postIDs[0], false
+	}
+
+	return "", true
+}
+
+// targetPostID returns the post ID of @@postid, or of context ID id in
+// target (#channel or user).
+func (u *User) targetPostID(target, id string) string {
+	if strings.HasPrefix(id, "@@") || len(id) != 3 {
+		return strings.TrimPrefix(id, "@@")
+	}
+
+	// context IDs are per channel/user
+	var contextID string
+
+	if strings.HasPrefix(target, "#") {
+		contextID = u.channelIDByName(target)
+	} else if targetUser, exists := u.Srv.HasUser(target); exists && targetUser.Ghost {
+		contextID = targetUser.User
+	}
+
+	return u.contextPostID(contextID, id)
+}
+
+// contextPostID returns the post ID of a context ID (eg abc) in the channel
+// (or user) contextID, or "" if it's unknown.
+func (u *User) contextPostID(contextID, id string) string {
+	counter, err := strconv.ParseInt(id, 16, 0)
+	if err != nil {
+		return ""
+	}
+
+	u.msgMapMutex.RLock()
+	defer u.msgMapMutex.RUnlock()
+
+	return u.msgMapIndex[contextID][int(counter)]
+}
+
+// isContextID returns true if id is a context ID, eg abc.
+func isContextID(id string) bool {
+	_, err := strconv.ParseUint(id, 16, 12)
+
+	return len(id) == 3 && err == nil
+}
+
+// contextPostIDs returns the post IDs of the context ID id (eg abc) in all
+// channels and users.
+func (u *User) contextPostIDs(id string) []string {
+	counter, err := strconv.ParseInt(id, 16, 0)
+	if err != nil {
+		return nil
+	}
+
+	u.msgMapMutex.RLock()
+	defer u.msgMapMutex.RUnlock()
+
+	var postIDs []string
+
+	for _, index := range u.msgMapIndex {
+		if postID, ok := index[int(counter)]; ok {
+			postIDs = append(postIDs, postID)
+		}
+	}
+
+	return postIDs
+}
+
+func action(u *User, toUser *User, args []string, service string) {
+	if service == "slack" {
+		u.MsgUser(toUser, "not implemented")
+		return
+	}
+
+	usage := func() {
+		u.MsgUser(toUser, "need ACTION [#<channel>|<user>] <@@postid|contextid> <button> [option]")
+		u.MsgUser(toUser, "e.g. ACTION abc 1, ACTION #builds abc 1 or ACTION @@cfrakpwix7y8pgzux6ta76pm9c approve")
+	}
+
+	// the channel/user of the context ID is optional
+	target := ""
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "@@") &&
+		(strings.HasPrefix(args[0], "#") || !isContextID(args[0]) || len(args) == 4) {
+		target, args = args[0], args[1:]
+	}
+
+	if len(args) < 2 || len(args) > 3 {
+		usage()
+		return
+	}
+
+	option := ""
+	if len(args) == 3 {
+		option = args[2]
+	}
+
+	postID, ambiguous := u.resolvePostID(target, args[0])
+	if ambiguous {
+		u.MsgUser(toUser, "context ID "+args[0]+" is used in multiple channels, use ACTION <#channel|user> "+args[0]+" ...")
+		return
+	}
+
+	if len(postID) != 26 {
+		u.MsgUser(toUser, "message "+args[0]+" not found")
+		return
+	}
+
+	if err := u.br.DoPostAction(postID, args[1], option); err != nil {
+		u.MsgUser(toUser, "action failed: "+err.Error())
+		return
+	}
+
+	u.MsgUser(toUser, "action "+args[1]+" done")
 }
 
 func updatelastviewed(u *User, toUser *User, args []string, service string) {
@@ -410,17 +704,358 @@ func updatelastviewed(u *User, toUser *User, args []string, service string) {
 	u.MsgUser(toUser, fmt.Sprintf("set viewed for %s", args[0]))
 }
 
+func cmd(u *User, toUser *User, args []string, service string) {
+	if service == "slack" {
+		u.MsgUser(toUser, "not implemented")
+		return
+	}
+
+	if len(args) < 2 || !strings.HasPrefix(args[0], "#") || !strings.HasPrefix(args[1], "/") {
+		u.MsgUser(toUser, "need CMD <#channel> </command> [args]")
+		u.MsgUser(toUser, "e.g. CMD #bugs /remind me to fix this in 1 hour")
+		return
+	}
+
+	channelID := u.channelIDByName(args[0])
+	if channelID == "" {
+		u.MsgUser(toUser, "channel does not exist")
+		return
+	}
+
+	command := strings.Join(args[1:], " ")
+
+	if err := u.br.ExecuteCommand(channelID, command); err != nil {
+		u.MsgUser(toUser, fmt.Sprintf("command %s failed: %s", command, err))
+	}
+}
+
+func mute(u *User, toUser *User, args []string, service string) {
+	muteChannel(u, toUser, args, service, true)
+}
+
+func unmute(u *User, toUser *User, args []string, service string) {
+	muteChannel(u, toUser, args, service, false)
+}
+
+// muteChannel mutes or unmutes a channel or direct message in mattermost.
+func muteChannel(u *User, toUser *User, args []string, service string, mute bool) {
+	if service == "slack" {
+		u.MsgUser(toUser, "not implemented")
+		return
+	}
+
+	command := "UNMUTE"
+	if mute {
+		command = "MUTE"
+	}
+
+	if len(args) != 1 {
+		u.MsgUser(toUser, "need "+command+" (#<channel>|<user>)")
+		u.MsgUser(toUser, "e.g. "+command+" #random")
+		return
+	}
+
+	var channelID string
+
+	if strings.HasPrefix(args[0], "#") {
+		channelID = u.channelIDByName(args[0])
+	} else if muteUser, exists := u.Srv.HasUser(args[0]); exists && muteUser.Ghost {
+		// We need to sort the two user IDs to construct the DM
+		// channel name.
+		userIDs := []string{u.User, muteUser.User}
+		sort.Strings(userIDs)
+		channelID = u.br.GetChannelID(userIDs[0]+"__"+userIDs[1], u.br.GetMe().TeamID)
+	}
+
+	if channelID == "" {
+		u.MsgUser(toUser, args[0]+" not found")
+		return
+	}
+
+	if err := u.br.MuteChannel(channelID, mute); err != nil {
+		u.MsgUser(toUser, strings.ToLower(command)+" "+args[0]+" failed: "+err.Error())
+		return
+	}
+
+	if mute {
+		u.MsgUser(toUser, args[0]+" muted")
+	} else {
+		u.MsgUser(toUser, args[0]+" unmuted")
+	}
+}
+
+func archive(u *User, toUser *User, args []string, service string) {
+	archiveChannel(u, toUser, args, true)
+}
+
+func unarchive(u *User, toUser *User, args []string, service string) {
+	archiveChannel(u, toUser, args, false)
+}
+
+// channelIDByName returns the ID of #channel, or of #team/channel in another
+// of our teams, "" if there's no such channel.
+func (u *User) channelIDByName(name string) string {
+	return u.br.GetChannelID(strings.TrimPrefix(name, "#"), u.br.GetMe().TeamID)
+}
+
+// archiveChannel archives or unarchives a channel, this needs the permission
+// to do so on the server.
+func archiveChannel(u *User, toUser *User, args []string, archive bool) {
+	command := "UNARCHIVE"
+	if archive {
+		command = "ARCHIVE"
+	}
+
+	if len(args) != 1 || !strings.HasPrefix(args[0], "#") {
+		u.MsgUser(toUser, "need "+command+" #<channel>")
+		u.MsgUser(toUser, "e.g. "+command+" #old-project")
+		return
+	}
+
+	channelID := u.channelIDByName(args[0])
+	if channelID == "" {
+		u.MsgUser(toUser, args[0]+" not found")
+		return
+	}
+
+	if err := u.br.ArchiveChannel(channelID, archive); err != nil {
+		u.MsgUser(toUser, strings.ToLower(command)+" "+args[0]+" failed: "+err.Error())
+		return
+	}
+
+	if archive {
+		u.MsgUser(toUser, args[0]+" archived")
+	} else {
+		u.MsgUser(toUser, args[0]+" unarchived")
+	}
+}
+
+func group(u *User, toUser *User, args []string, service string) {
+	if service == "slack" {
+		u.MsgUser(toUser, "not implemented")
+		return
+	}
+
+	name, err := u.createGroup(args)
+	if err != nil {
+		u.MsgUser(toUser, "creating group failed: "+err.Error())
+		return
+	}
+
+	u.MsgUser(toUser, "group "+name+" created")
+}
+
+func upload(u *User, toUser *User, args []string, service string) {
+	if len(args) < 2 {
+		u.MsgUser(toUser, "need UPLOAD <#channel|nick> <url-or-path> [caption]")
+		u.MsgUser(toUser, "e.g. UPLOAD #bugs https://example.com/crash.log the crash log")
+		return
+	}
+
+	var channelID, userID string
+
+	if strings.HasPrefix(args[0], "#") {
+		channelID = u.channelIDByName(args[0])
+		if channelID == "" {
+			u.MsgUser(toUser, "channel does not exist")
+			return
+		}
+	} else if uploadUser, exists := u.Srv.HasUser(args[0]); exists && uploadUser.Ghost {
+		userID = uploadUser.User
+	} else {
+		u.MsgUser(toUser, fmt.Sprintf("user %s does not exist", args[0]))
+		return
+	}
+
+	caption := strings.Join(args[2:], " ")
+
+	// downloading and uploading may take a while, don't block our commands
+	go func() {
+		filename, data, err := u.readUpload(args[1])
+		if err != nil {
+			u.MsgUser(toUser, fmt.Sprintf("upload of %s failed: %s", args[1], err))
+			return
+		}
+
+		if userID != "" {
+			_, err = u.br.UploadUser(userID, filename, data, caption)
+		} else {
+			_, err = u.br.UploadChannel(channelID, filename, data, caption)
+		}
+
+		if err != nil {
+			u.MsgUser(toUser, fmt.Sprintf("upload of %s failed: %s", filename, err))
+			return
+		}
+
+		u.MsgUser(toUser, fmt.Sprintf("uploaded %s to %s", filename, args[0]))
+	}()
+}
+
+func threads(u *User, toUser *User, args []string, service string) {
+	if service == "slack" {
+		u.MsgUser(toUser, "not implemented")
+		return
+	}
+
+	switch {
+	case len(args) == 0:
+		if err := u.listThreads(toUser, true); err != nil {
+			u.MsgUser(toUser, "listing threads failed: "+err.Error())
+		}
+	case len(args) == 1 && strings.ToLower(args[0]) == "all":
+		if err := u.listThreads(toUser, false); err != nil {
+			u.MsgUser(toUser, "listing threads failed: "+err.Error())
+		}
+	case len(args) == 2 && strings.ToLower(args[0]) == "read":
+		thread, err := u.findThread(args[1])
+		if err != nil {
+			u.MsgUser(toUser, err.Error())
+			return
+		}
+
+		if err := u.br.MarkThreadRead(thread.ChannelID, thread.ID); err != nil {
+			u.MsgUser(toUser, "marking thread as read failed: "+err.Error())
+			return
+		}
+
+		u.MsgUser(toUser, "marked thread "+args[1]+" as read")
+	default:
+		u.MsgUser(toUser, "need THREADS [all] or THREADS read <id>")
+		u.MsgUser(toUser, "e.g. THREADS read abc (with abc the context ID shown by THREADS)")
+	}
+}
+
+func status(u *User, toUser *User, args []string, service string) {
+	usage := func() {
+		u.MsgUser(toUser, "need STATUS [online|away|offline|dnd [duration]|custom [duration] [:emoji:] <text>|clear]")
+		u.MsgUser(toUser, "e.g. STATUS dnd 1h or STATUS custom 2h :hamburger: having lunch")
+	}
+
+	if len(args) == 0 {
+		me := u.br.GetMe().User
+		current, _ := u.br.StatusUser(me)
+		u.MsgUser(toUser, "status: "+current)
+
+		if custom := u.br.GetCustomStatus(me); custom != "" {
+			u.MsgUser(toUser, "custom status: "+custom)
+		}
+
+		return
+	}
+
+	var err error
+
+	switch strings.ToLower(args[0]) {
+	case "online", "away", "offline":
+		err = u.br.SetStatus(strings.ToLower(args[0]))
+	case "dnd":
+		var until time.Duration
+
+		if len(args) > 1 {
+			until, err = time.ParseDuration(args[1])
+			if err != nil {
+				usage()
+				return
+			}
+		}
+
+		err = u.br.SetDND(until)
+	case "custom":
+		var expires time.Duration
+
+		args = args[1:]
+
+		if len(args) > 0 {
+			if d, perr := time.ParseDuration(args[0]); perr == nil {
+				expires = d
+				args = args[1:]
+			}
+		}
+
+		emoji, text := parseCustomStatus(strings.Join(args, " "))
+		if emoji == "" && text == "" {
+			usage()
+			return
+		}
+
+		err = u.br.SetCustomStatus(emoji, text, expires)
+	case "clear":
+		err = u.br.SetCustomStatus("", "", 0)
+	default:
+		usage()
+		return
+	}
+
+	if err != nil {
+		u.MsgUser(toUser, "setting status failed: "+err.Error())
+		return
+	}
+
+	u.MsgUser(toUser, "status updated")
+}
+
+// parseCustomStatus splits a custom status in an emoji and text, eg
+// ":hamburger: having lunch" returns "hamburger" and "having lunch".
+func parseCustomStatus(status string) (string, string) {
+	status = strings.TrimSpace(status)
+
+	if !strings.HasPrefix(status, ":") {
+		return "", status
+	}
+
+	end := strings.Index(status[1:], ":")
+	if end < 1 || strings.ContainsAny(status[1:end+1], " \t") {
+		return "", status
+	}
+
+	return status[1 : end+1], strings.TrimSpace(status[end+2:])
+}
+
 var cmds = map[string]Command{
+	"action":           {handler: action, login: true, minParams: 2, maxParams: 4},
+	"archive":          {handler: archive, login: true, minParams: 1, maxParams: 1},
+	"unarchive":        {handler: unarchive, login: true, minParams: 1, maxParams: 1},
 	"logout":           {handler: logout, login: true, minParams: 0, maxParams: 0},
+	"cmd":              {handler: cmd, login: true, minParams: 2, maxParams: -1},
+	"group":            {handler: group, login: true, minParams: 2, maxParams: -1},
+	"mute":             {handler: mute, login: true, minParams: 1, maxParams: 1},
+	"unmute":           {handler: unmute, login: true, minParams: 1, maxParams: 1},
+	"localsearch":      {handler: localSearch, login: true, minParams: 1, maxParams: -1},
 	"login":            {handler: login, minParams: 2, maxParams: 5},
+	"mfa":              {handler: mfa, minParams: 1, maxParams: 1},
 	"search":           {handler: search, login: true, minParams: 1, maxParams: -1},
 	"searchusers":      {handler: searchUsers, login: true, minParams: 1, maxParams: -1},
+	"status":           {handler: status, login: true, minParams: 0, maxParams: -1},
+	"thread":           {handler: thread, login: true, minParams: 1, maxParams: 2},
+	"threads":          {handler: threads, login: true, minParams: 0, maxParams: 2},
 	"scrollback":       {handler: scrollback, login: true, minParams: 2, maxParams: 2},
 	"updatelastviewed": {handler: updatelastviewed, login: true, minParams: 1, maxParams: 1},
+	"upload":           {handler: upload, login: true, minParams: 2, maxParams: -1},
+}
+
+func isMFACode(msg string) bool {
+	msg = strings.TrimSpace(msg)
+	if msg == "" {
+		return false
+	}
+
+	for _, r := range msg {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (u *User) handleServiceBot(service string, toUser *User, msg string) {
 	// func (u *User) handleMMServiceBot(toUser *User, msg string) {
+	// a login is waiting for a MFA code, the code can be sent as is
+	if u.pendingMFA != nil && service == "mattermost" && isMFACode(msg) {
+		msg = "mfa " + strings.TrimSpace(msg)
+	}
+
 	commands, err := parseCommandString(msg)
 	if err != nil {
 		u.MsgUser(toUser, fmt.Sprintf("\"%s\" is improperly formatted", msg))
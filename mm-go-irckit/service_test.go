@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/42wim/matterircd/bridge"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -104,3 +105,83 @@ func TestParseCommandString(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCustomStatus(t *testing.T) {
+	tests := []struct {
+		Desc  string
+		Value string
+		Emoji string
+		Text  string
+	}{
+		{Desc: "emoji and text", Value: ":hamburger: having lunch", Emoji: "hamburger", Text: "having lunch"},
+		{Desc: "only emoji", Value: ":palm_tree:", Emoji: "palm_tree"},
+		{Desc: "only text", Value: "in a meeting", Text: "in a meeting"},
+		{Desc: "colon in text", Value: "meeting: budget", Text: "meeting: budget"},
+		{Desc: "no emoji", Value: ":not an emoji: text", Text: ":not an emoji: text"},
+		{Desc: "empty", Value: "  "},
+	}
+
+	for _, tc := range tests {
+		emoji, text := parseCustomStatus(tc.Value)
+		assert.Equal(t, tc.Emoji, emoji, tc.Desc)
+		assert.Equal(t, tc.Text, text, tc.Desc)
+	}
+}
+
+func TestContextPostIDs(t *testing.T) {
+	u := newMsgMapUser()
+	u.setMsgMap("chan1", "post1", 0xabc)
+	u.setMsgMap("chan2", "post2", 0xabc)
+	u.setMsgMap("chan2", "post3", 0x001)
+
+	assert.True(t, isContextID("abc"))
+	assert.False(t, isContextID("xyz"))
+	assert.False(t, isContextID("abcd"))
+
+	assert.Equal(t, []string{"post3"}, u.contextPostIDs("001"))
+	assert.ElementsMatch(t, []string{"post1", "post2"}, u.contextPostIDs("abc"))
+	assert.Empty(t, u.contextPostIDs("fff"))
+}
+
+// threadBridge remembers the threads asked for.
+type threadBridge struct {
+	fakeBridge
+
+	asked []string
+}
+
+func (b *threadBridge) GetPostThread(postID string) interface{} {
+	b.asked = append(b.asked, postID)
+
+	return nil
+}
+
+func TestThreadWithoutTarget(t *testing.T) {
+	br := &threadBridge{}
+	conn := &recordConn{}
+
+	u := newBridgeUser(br)
+	u.Conn = conn
+	u.msgMap = make(map[string]map[string]int)
+	u.msgMapIndex = make(map[string]map[int]string)
+	u.setMsgMap("chan1", "post1abcdefghijklmnopqrstu", 0xabc)
+	u.setMsgMap("chan2", "post2abcdefghijklmnopqrstu", 0xabc)
+	u.setMsgMap("chan2", "post3abcdefghijklmnopqrstu", 0x001)
+
+	svc := &User{UserInfo: &bridge.UserInfo{Nick: "mattermost", User: "mattermost", Host: "service"}}
+
+	thread(u, svc, []string{"001"}, "mattermost")
+	assert.Equal(t, []string{"post3abcdefghijklmnopqrstu"}, br.asked)
+
+	thread(u, svc, []string{"abc"}, "mattermost")
+	assert.Len(t, br.asked, 1, "ambiguous context ID")
+
+	thread(u, svc, []string{"fff"}, "mattermost")
+	assert.Len(t, br.asked, 1, "unknown context ID")
+
+	assert.Equal(t, []string{
+		"thread 001 not found",
+		"context ID abc is used in multiple channels, use THREAD <#channel|user> abc",
+		"thread fff not found",
+	}, conn.texts())
+}
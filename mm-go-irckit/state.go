@@ -0,0 +1,413 @@
+package irckit
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const stateVersion = 1
+
+// userState is the state of an account (protocol + server + user) that
+// survives a logout or restart of matterircd.
+type userState struct {
+	Version      int              `json:"version"`
+	CreatedAt    int64            `json:"created_at"`
+	SavedAt      int64            `json:"saved_at"`
+	LastViewedAt map[string]int64 `json:"last_viewed_at"`
+	// MsgCounter and MsgMap are the context IDs (eg [abc]) of the messages we've shown
+	MsgCounter map[string]int            `json:"msg_counter,omitempty"`
+	MsgMap     map[string]map[string]int `json:"msg_map,omitempty"`
+	// UnfollowedThreads are the root post IDs of the threads we unfollowed, with the time we did
+	UnfollowedThreads map[string]int64 `json:"unfollowed_threads,omitempty"`
+}
+
+// savedMsgMapSize is the maximum number of context IDs saved per channel.
+const savedMsgMapSize = 1024
+
+var stateFileReplacer = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// stateDir returns the directory where the state files are saved.
+// When StateDir isn't set but LastViewedSaveFile is, its directory is used.
+func (u *User) stateDir() string {
+	if dir := u.v.GetString(u.br.Protocol() + ".statedir"); dir != "" {
+		return dir
+	}
+
+	if statePath := u.v.GetString(u.br.Protocol() + ".lastviewedsavefile"); statePath != "" {
+		return filepath.Dir(statePath)
+	}
+
+	return ""
+}
+
+// stateFile returns the state file of the logged in account, eg
+// mattermost-chat.mycompany.com-<userid>.json
+func (u *User) stateFile() string {
+	dir := u.stateDir()
+	if dir == "" {
+		return ""
+	}
+
+	me := u.br.GetMe()
+
+	server := u.Credentials.Server
+	if server == "" {
+		server = me.TeamID
+	}
+
+	name := strings.Join([]string{u.br.Protocol(), server, me.User}, "-")
+
+	return filepath.Join(dir, stateFileReplacer.ReplaceAllString(name, "_")+".json")
+}
+
+// loadState loads the state of the logged in account, an old lastViewedAt
+// state file gets migrated when we don't have any state yet.
+func (u *User) loadState() {
+	defer func() {
+		u.stateLoaded = true
+	}()
+
+	statePath := u.stateFile()
+	u.statePath = statePath
+	if statePath == "" {
+		return
+	}
+
+	st, err := loadStateFile(statePath)
+
+	switch {
+	case os.IsNotExist(err):
+		st = &userState{
+			CreatedAt:    model.GetMillis(),
+			LastViewedAt: u.migrateLastViewedAt(),
+		}
+	case err != nil:
+		logger.Warning("Unable to load saved state, using empty values: ", err)
+		return
+	}
+
+	if st.LastViewedAt == nil || u.stateIsStale(st) {
+		st.LastViewedAt = make(map[string]int64)
+		st.MsgCounter = nil
+		st.MsgMap = nil
+	}
+
+	u.lastViewedAtMutex.Lock()
+	for channelID, lastViewedAt := range st.LastViewedAt {
+		if lastViewedAt > u.lastViewedAt[channelID] {
+			u.lastViewedAt[channelID] = lastViewedAt
+		}
+	}
+	u.lastViewedAtMutex.Unlock()
+
+	u.msgMapMutex.Lock()
+	for channelID, counter := range st.MsgCounter {
+		u.msgCounter[channelID] = counter
+	}
+	for channelID, m := range st.MsgMap {
+		for messageID, counter := range m {
+			u.setMsgMap(channelID, messageID, counter)
+		}
+	}
+	u.msgMapMutex.Unlock()
+
+	u.followedThreadsMutex.Lock()
+	for rootID, unfollowedAt := range st.UnfollowedThreads {
+		u.unfollowedThreads[rootID] = unfollowedAt
+	}
+	u.followedThreadsMutex.Unlock()
+
+	u.stateCreatedAt = st.CreatedAt
+
+	logger.Infof("Loaded state %s from %s", statePath, time.Unix(st.SavedAt/1000, 0))
+
+	u.saveState()
+}
+
+const defaultStaleDuration = int64((30 * 24 * time.Hour) / time.Millisecond)
+
+func (u *User) stateIsStale(st *userState) bool {
+	stale := defaultStaleDuration
+
+	val, err := time.ParseDuration(u.v.GetString(u.br.Protocol() + ".lastviewedstaleduration"))
+	if err == nil {
+		stale = val.Milliseconds()
+	}
+
+	if st.SavedAt > 0 && st.SavedAt < model.GetMillis()-stale {
+		logger.Debug("State stale? Last saved too old: ", time.Unix(st.SavedAt/1000, 0))
+		return true
+	}
+
+	return false
+}
+
+// saveState saves the state of the logged in account.
+func (u *User) saveState() {
+	u.stateMutex.Lock()
+	defer u.stateMutex.Unlock()
+
+	statePath := u.statePath
+	if statePath == "" {
+		return
+	}
+
+	st := &userState{
+		Version:      stateVersion,
+		CreatedAt:    u.stateCreatedAt,
+		SavedAt:      model.GetMillis(),
+		LastViewedAt: make(map[string]int64),
+	}
+
+	u.lastViewedAtMutex.RLock()
+	for channelID, lastViewedAt := range u.lastViewedAt {
+		st.LastViewedAt[channelID] = lastViewedAt
+	}
+	u.lastViewedAtMutex.RUnlock()
+
+	st.MsgCounter, st.MsgMap = u.savedMsgMap()
+	st.UnfollowedThreads = u.savedUnfollowedThreads()
+
+	logger.Debug("Saving state to ", statePath)
+
+	if err := saveStateFile(statePath, st); err != nil {
+		logger.Error("Unable to save state: ", err)
+		return
+	}
+
+	u.stateSaved = st.SavedAt
+}
+
+// savedMsgMap returns a copy of the msgCounter and msgMap, only containing the
+// most recent context IDs of every channel.
+func (u *User) savedMsgMap() (map[string]int, map[string]map[string]int) {
+	u.msgMapMutex.RLock()
+	defer u.msgMapMutex.RUnlock()
+
+	msgCounter := make(map[string]int)
+	msgMap := make(map[string]map[string]int)
+
+	for channelID, m := range u.msgMap {
+		current := u.msgCounter[channelID]
+		msgCounter[channelID] = current
+		msgMap[channelID] = make(map[string]int)
+
+		for messageID, counter := range m {
+			// the counter wraps, so look at the distance from the current counter
+			if (current-counter+maxMsgCounter)%maxMsgCounter < savedMsgMapSize {
+				msgMap[channelID][messageID] = counter
+			}
+		}
+	}
+
+	return msgCounter, msgMap
+}
+
+// savedUnfollowedThreads returns a copy of the unfollowed threads, without the
+// ones unfollowed longer than defaultStaleDuration ago.
+func (u *User) savedUnfollowedThreads() map[string]int64 {
+	u.followedThreadsMutex.Lock()
+	defer u.followedThreadsMutex.Unlock()
+
+	threads := make(map[string]int64)
+	since := model.GetMillis() - defaultStaleDuration
+
+	for rootID, unfollowedAt := range u.unfollowedThreads {
+		if unfollowedAt > since {
+			threads[rootID] = unfollowedAt
+		}
+	}
+
+	return threads
+}
+
+func loadStateFile(statePath string) (*userState, error) {
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &userState{}
+
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("json decoding failed: %s", err)
+	}
+
+	if st.Version != stateVersion {
+		return nil, fmt.Errorf("state version mismatch: %d vs. %d", st.Version, stateVersion)
+	}
+
+	return st, nil
+}
+
+// saveStateFile writes the state to a temporary file first and renames it, so
+// we never end up with a half written state file.
+func saveStateFile(statePath string, st *userState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(statePath), 0o700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(statePath), filepath.Base(statePath)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), statePath)
+}
+
+// migrateLastViewedAt returns the lastViewedAt values of the old (shared)
+// gob state file, if there is one. See migrateLastViewedAtFile.
+func (u *User) migrateLastViewedAt() map[string]int64 {
+	statePath := u.v.GetString(u.br.Protocol() + ".lastviewedsavefile")
+	if statePath == "" {
+		return make(map[string]int64)
+	}
+
+	channels := make(map[string]bool)
+
+	for _, ch := range u.br.GetChannels() {
+		channels[ch.ID] = true
+	}
+
+	staleDuration := u.v.GetString(u.br.Protocol() + ".lastviewedstaleduration")
+
+	lastViewedAt, err := migrateLastViewedAtFile(statePath, staleDuration, channels)
+	switch {
+	case err != nil:
+		logger.Warning("Unable to migrate saved lastViewedAt: ", err)
+	case len(lastViewedAt) > 0:
+		logger.Infof("Migrated lastViewedAt of %d channels from %s", len(lastViewedAt), statePath)
+	}
+
+	return lastViewedAt
+}
+
+// migrateLastViewedAtFile returns the lastViewedAt values of the old gob
+// state file for the channels of the account. The file doesn't know which
+// account (or protocol) wrote it, so only the first account logging in gets
+// them and the file is renamed to <file>.migrated afterwards.
+func migrateLastViewedAtFile(statePath, staleDuration string, channels map[string]bool) (map[string]int64, error) {
+	lastViewedAt := make(map[string]int64)
+
+	if _, err := os.Stat(statePath); os.IsNotExist(err) {
+		return lastViewedAt, nil
+	}
+
+	saved, err := loadLastViewedAtStateFile(statePath, staleDuration)
+	if err != nil {
+		return lastViewedAt, err
+	}
+
+	for channelID, viewedAt := range saved {
+		if channels[channelID] {
+			lastViewedAt[channelID] = viewedAt
+		}
+	}
+
+	return lastViewedAt, os.Rename(statePath, statePath+".migrated")
+}
+
+const defaultSaveInterval = int64((5 * time.Minute) / time.Millisecond)
+
+func (u *User) saveLastViewedAt(channelID string) {
+	if channelID != "" {
+		u.lastViewedAtMutex.Lock()
+		u.lastViewedAt[channelID] = model.GetMillis()
+		u.lastViewedAtMutex.Unlock()
+	}
+
+	// We only want to save or dump out saved state on new
+	// messages after X time.
+	var saveInterval int64
+	val, err := time.ParseDuration(u.v.GetString(u.br.Protocol() + ".lastviewedsaveinterval"))
+	if err != nil {
+		saveInterval = defaultSaveInterval
+	} else {
+		saveInterval = val.Milliseconds()
+	}
+
+	if u.stateSaved < (model.GetMillis() - saveInterval) {
+		u.saveState()
+	}
+}
+
+const lastViewedStateFormat = int64(1)
+
+// loadLastViewedAtStateFile loads the old gob lastViewedAt state file.
+func loadLastViewedAtStateFile(statePath string, staleDuration string) (map[string]int64, error) {
+	f, err := os.Open(statePath)
+	if err != nil {
+		logger.Debug("Unable to load lastViewedAt: ", err)
+		return nil, err
+	}
+	defer f.Close()
+
+	var lastViewedAt map[string]int64
+	err = gob.NewDecoder(f).Decode(&lastViewedAt)
+	if err != nil {
+		logger.Debug("Unable to load lastViewedAt: ", err)
+		return nil, err
+	}
+
+	if lastViewedAt["__LastViewedStateFormat__"] != lastViewedStateFormat {
+		logger.Debug("State format version mismatch: ", lastViewedAt["__LastViewedStateFormat__"], " vs. ", lastViewedStateFormat)
+		return nil, errors.New("version mismatch")
+	}
+	checksum := lastViewedAt["__LastViewedStateChecksum__"]
+	createtime := lastViewedAt["__LastViewedStateCreateTime__"]
+	savedtime := lastViewedAt["__LastViewedStateSavedTime__"]
+	if createtime^savedtime != checksum {
+		logger.Debug("Checksum mismatch: (saved checksum, state file creation, last saved time)", checksum, createtime, savedtime)
+		return nil, errors.New("checksum mismatch")
+	}
+
+	currentTime := model.GetMillis()
+
+	// Check if stale, time last saved older than defined
+	var stale int64
+	val, err := time.ParseDuration(staleDuration)
+	if err != nil {
+		stale = defaultStaleDuration
+	} else {
+		stale = val.Milliseconds()
+	}
+
+	lastSaved, ok := lastViewedAt["__LastViewedStateSavedTime__"]
+	if !ok || (lastSaved > 0 && lastSaved < currentTime-stale) {
+		logger.Debug("File stale? Last saved too old: ", time.Unix(lastViewedAt["__LastViewedStateSavedTime__"]/1000, 0))
+		return nil, errors.New("stale lastViewedAt state file")
+	}
+
+	return lastViewedAt, nil
+}
@@ -0,0 +1,172 @@
+package irckit
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMsgMapUser() *User {
+	u := &User{}
+	u.msgMap = make(map[string]map[string]int)
+	u.msgMapIndex = make(map[string]map[int]string)
+	u.msgCounter = make(map[string]int)
+
+	return u
+}
+
+func TestIncreaseMsgCounterEvicts(t *testing.T) {
+	u := newMsgMapUser()
+	u.msgCounter["chan"] = maxMsgCounter - 1
+	u.setMsgMap("chan", "old", 0)
+	u.setMsgMap("chan", "keep", 1)
+
+	assert.Equal(t, 0, u.increaseMsgCounter("chan"))
+	assert.Equal(t, map[string]int{"keep": 1}, u.msgMap["chan"])
+	assert.Equal(t, map[int]string{1: "keep"}, u.msgMapIndex["chan"])
+}
+
+func TestSavedMsgMapIsBounded(t *testing.T) {
+	u := newMsgMapUser()
+	for i := 0; i < maxMsgCounter+10; i++ {
+		counter := u.increaseMsgCounter("chan")
+		u.setMsgMap("chan", fmt.Sprintf("post%d", i), counter)
+	}
+
+	msgCounter, msgMap := u.savedMsgMap()
+	assert.Equal(t, u.msgCounter["chan"], msgCounter["chan"])
+	assert.Len(t, msgMap["chan"], savedMsgMapSize)
+
+	for _, counter := range msgMap["chan"] {
+		assert.True(t, (msgCounter["chan"]-counter+maxMsgCounter)%maxMsgCounter < savedMsgMapSize)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	statePath := filepath.Join(dir, "sub", "state.json")
+
+	_, err = loadStateFile(statePath)
+	assert.True(t, os.IsNotExist(err))
+
+	st := &userState{
+		Version:      stateVersion,
+		LastViewedAt: map[string]int64{"chan": 42},
+		MsgCounter:   map[string]int{"chan": 2},
+		MsgMap:       map[string]map[string]int{"chan": {"post1": 1, "post2": 2}},
+	}
+
+	assert.NoError(t, saveStateFile(statePath, st))
+
+	loaded, err := loadStateFile(statePath)
+	assert.NoError(t, err)
+	assert.Equal(t, st, loaded)
+
+	files, err := ioutil.ReadDir(filepath.Dir(statePath))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1, "temporary file not removed")
+}
+
+func TestMigrateLastViewedAtFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	statePath := filepath.Join(dir, "matterircd-lastsaved.db")
+
+	now := model.GetMillis()
+	saved := map[string]int64{
+		"__LastViewedStateFormat__":     lastViewedStateFormat,
+		"__LastViewedStateCreateTime__": now - 1000,
+		"__LastViewedStateSavedTime__":  now,
+		"__LastViewedStateChecksum__":   (now - 1000) ^ now,
+		"mine":                          42,
+		"other":                         43,
+	}
+
+	f, err := os.Create(statePath)
+	assert.NoError(t, err)
+	assert.NoError(t, gob.NewEncoder(f).Encode(saved))
+	assert.NoError(t, f.Close())
+
+	channels := map[string]bool{"mine": true}
+
+	lastViewedAt, err := migrateLastViewedAtFile(statePath, "30d", channels)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"mine": 42}, lastViewedAt)
+
+	_, err = os.Stat(statePath + ".migrated")
+	assert.NoError(t, err)
+
+	// the next account doesn't get anything
+	lastViewedAt, err = migrateLastViewedAtFile(statePath, "30d", map[string]bool{"other": true})
+	assert.NoError(t, err)
+	assert.Empty(t, lastViewedAt)
+}
+
+func TestUnfollowedThreads(t *testing.T) {
+	u := newMsgMapUser()
+	u.unfollowedThreads = map[string]int64{
+		"root": model.GetMillis(),
+		"old":  model.GetMillis() - defaultStaleDuration - 1,
+	}
+
+	bob := &bridge.UserInfo{Nick: "bob"}
+	me := &bridge.UserInfo{Nick: "me", Me: true}
+
+	assert.False(t, u.showInThreadChannel(false, "other", bob))
+	assert.True(t, u.showInThreadChannel(true, "other", bob))
+	assert.False(t, u.showInThreadChannel(true, "root", bob), "unfollowed")
+
+	// only recently unfollowed threads are saved
+	saved := u.savedUnfollowedThreads()
+	assert.Len(t, saved, 1)
+	assert.Contains(t, saved, "root")
+
+	assert.True(t, u.showInThreadChannel(true, "root", me), "our reply follows again")
+	assert.True(t, u.showInThreadChannel(true, "root", bob))
+}
+
+func TestEventsWaitForLogin(t *testing.T) {
+	u := newBridgeUser(&fakeBridge{})
+	u.eventChan = make(chan *bridge.Event, 1)
+
+	done := make(chan struct{})
+
+	// a login in progress
+	u.eventMutex.Lock()
+
+	go func() {
+		u.handleEventChan()
+		close(done)
+	}()
+
+	u.eventChan <- &bridge.Event{Type: "logout", Data: &bridge.LogoutEvent{}}
+
+	select {
+	case <-done:
+		t.Fatal("event handled during login")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	u.eventMutex.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("event not handled after login")
+	}
+}
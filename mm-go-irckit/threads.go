@@ -0,0 +1,164 @@
+package irckit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// threadTextLen is the maximum length of the root message shown for a thread.
+const threadTextLen = 80
+
+// formatThread formats a thread we follow, eg
+// #town-square [abc] @nick: can someone review my PR? (5 replies, 2 unread, 1 mention)
+func (u *User) formatThread(thread *bridge.ThreadInfo) string {
+	name := u.br.GetChannelName(thread.ChannelID)
+	contextID := u.threadContextID(thread)
+
+	if strings.Contains(name, "__") {
+		name = "@" + u.br.GetUser(contextID).Nick
+	}
+
+	text := strings.SplitN(thread.Text, "\n", 2)[0]
+	if r := []rune(text); len(r) > threadTextLen {
+		text = string(r[:threadTextLen]) + "..."
+	}
+
+	counts := []string{fmt.Sprintf("%d replies", thread.ReplyCount)}
+
+	if thread.UnreadReplies > 0 {
+		counts = append(counts, fmt.Sprintf("%d unread", thread.UnreadReplies))
+	}
+
+	if thread.UnreadMentions > 0 {
+		counts = append(counts, fmt.Sprintf("%d mentions", thread.UnreadMentions))
+	}
+
+	return fmt.Sprintf("%s %s @%s: %s (%s)", name, u.prefixContext(contextID, thread.ID, "", ""),
+		thread.User.Nick, text, strings.Join(counts, ", "))
+}
+
+// threadContextID returns the ID of the channel (or user for DMs) used for the
+// context IDs of the thread.
+func (u *User) threadContextID(thread *bridge.ThreadInfo) string {
+	info, err := u.br.GetChannel(thread.ChannelID)
+	if err != nil {
+		return thread.ChannelID
+	}
+
+	return u.contextChannelID(info)
+}
+
+// findThread returns the followed thread with root post or context ID id,
+// from the last THREADS listing or the unread threads.
+func (u *User) findThread(id string) (*bridge.ThreadInfo, error) {
+	match := func(threads []*bridge.ThreadInfo) *bridge.ThreadInfo {
+		for _, thread := range threads {
+			if thread.ID == id || (len(id) == 3 && u.contextPostID(u.threadContextID(thread), id) == thread.ID) {
+				return thread
+			}
+		}
+
+		return nil
+	}
+
+	u.followedThreadsMutex.Lock()
+	thread := match(u.followedThreads)
+	u.followedThreadsMutex.Unlock()
+
+	if thread != nil {
+		return thread, nil
+	}
+
+	threads, err := u.br.GetThreads(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if thread = match(threads); thread == nil {
+		return nil, errors.New("thread " + id + " not found")
+	}
+
+	return thread, nil
+}
+
+// listThreads shows the (unread) threads we follow to toUser, or in the &threads channel.
+func (u *User) listThreads(toUser *User, unread bool) error {
+	threads, err := u.br.GetThreads(unread)
+	if err != nil {
+		return err
+	}
+
+	u.followedThreadsMutex.Lock()
+	u.followedThreads = threads
+	u.followedThreadsMutex.Unlock()
+
+	msg := func(text string) {
+		if toUser != nil {
+			u.MsgUser(toUser, text)
+			return
+		}
+
+		u.Srv.Channel("&threads").SpoofMessage(u.br.Protocol(), text)
+	}
+
+	if len(threads) == 0 {
+		if unread {
+			msg("no threads with unread replies")
+		} else {
+			msg("you don't follow any threads")
+		}
+
+		return nil
+	}
+
+	for _, thread := range threads {
+		msg(u.formatThread(thread))
+	}
+
+	return nil
+}
+
+// showInThreadChannel returns true if a reply to rootID that the bridge wants
+// to show in its thread channel (thread) should be shown there. Threads we
+// unfollowed by parting their channel are shown in the channel itself, until we
+// reply to them again.
+func (u *User) showInThreadChannel(thread bool, rootID string, sender *bridge.UserInfo) bool {
+	if !thread {
+		return false
+	}
+
+	u.followedThreadsMutex.Lock()
+	defer u.followedThreadsMutex.Unlock()
+
+	if sender.Me {
+		delete(u.unfollowedThreads, rootID)
+		return true
+	}
+
+	_, unfollowed := u.unfollowedThreads[rootID]
+
+	return !unfollowed
+}
+
+// unfollowThread remembers we unfollowed the thread of rootID, this is saved
+// in our state so it survives a reconnect.
+func (u *User) unfollowThread(rootID string) {
+	u.followedThreadsMutex.Lock()
+	u.unfollowedThreads[rootID] = model.GetMillis()
+	u.followedThreadsMutex.Unlock()
+
+	u.saveState()
+}
+
+// handleThreadUpdateEvent shows threads with new replies in the &threads channel.
+func (u *User) handleThreadUpdateEvent(event *bridge.ThreadUpdateEvent) {
+	if !u.v.GetBool(u.br.Protocol()+".threadschannel") || event.Thread.UnreadReplies == 0 {
+		return
+	}
+
+	u.Srv.Channel("&threads").SpoofNotice(u.br.Protocol(), u.formatThread(event.Thread))
+}
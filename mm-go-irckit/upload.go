@@ -0,0 +1,267 @@
+package irckit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxUploadSize is the maximum size of a file we upload (the mattermost default).
+const maxUploadSize = 100 * 1024 * 1024
+
+// readUpload returns the filename and contents of src, which is an URL or a
+// path in UploadDir.
+func (u *User) readUpload(src string) (string, []byte, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		if !u.v.GetBool(u.br.Protocol() + ".allowuploadurl") {
+			return "", nil, errors.New("uploading from an URL is not allowed")
+		}
+
+		return downloadUpload(src)
+	}
+
+	uploadDir := u.v.GetString(u.br.Protocol() + ".uploaddir")
+	if uploadDir == "" {
+		return "", nil, errors.New("uploading local files is not allowed")
+	}
+
+	uploadDir, err := filepath.Abs(uploadDir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	uploadDir, err = filepath.EvalSymlinks(uploadDir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// relative paths are relative to UploadDir
+	file := src
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(uploadDir, file)
+	}
+
+	file, err = filepath.EvalSymlinks(filepath.Clean(file))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if rel, err := filepath.Rel(uploadDir, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil, fmt.Errorf("%s is not in the upload directory", src)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer f.Close()
+
+	data, err := readMax(f)
+
+	return filepath.Base(file), data, err
+}
+
+func downloadUpload(src string) (string, []byte, error) {
+	client := &http.Client{Timeout: time.Minute}
+
+	resp, err := client.Get(src)
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("download of %s failed: %s", src, resp.Status)
+	}
+
+	filename := path.Base(resp.Request.URL.Path)
+	if filename == "/" || filename == "." {
+		filename = "upload"
+	}
+
+	data, err := readMax(resp.Body)
+
+	return filename, data, err
+}
+
+func readMax(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxUploadSize {
+		return nil, fmt.Errorf("file too large (max %d MB)", maxUploadSize/1024/1024)
+	}
+
+	return data, nil
+}
+
+type dccSend struct {
+	Filename string
+	Addr     string
+	Size     int64
+}
+
+// parseDCCSend parses a CTCP DCC SEND offer, eg
+// \x01DCC SEND "some file.txt" 3232235777 5000 1234\x01
+func parseDCCSend(msg string) (*dccSend, error) {
+	msg = strings.Trim(msg, "\x01")
+	if !strings.HasPrefix(msg, "DCC SEND ") {
+		return nil, errors.New("not a DCC SEND")
+	}
+
+	msg = strings.TrimPrefix(msg, "DCC SEND ")
+
+	var filename string
+
+	if strings.HasPrefix(msg, "\"") {
+		end := strings.Index(msg[1:], "\"")
+		if end < 0 {
+			return nil, errors.New("invalid DCC SEND filename")
+		}
+
+		filename = msg[1 : end+1]
+		msg = strings.TrimSpace(msg[end+2:])
+	} else {
+		sp := strings.SplitN(msg, " ", 2)
+		if len(sp) != 2 {
+			return nil, errors.New("invalid DCC SEND")
+		}
+
+		filename, msg = sp[0], sp[1]
+	}
+
+	fields := strings.Fields(msg)
+	if len(fields) < 3 {
+		return nil, errors.New("invalid DCC SEND")
+	}
+
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("invalid DCC SEND port")
+	}
+
+	// passive (reverse) DCC needs us to listen, which we don't do
+	if port == 0 {
+		return nil, errors.New("passive DCC is not supported")
+	}
+
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || size < 0 {
+		return nil, errors.New("invalid DCC SEND size")
+	}
+
+	// the address is an IPv4 address as integer, or an IPv6 address
+	host := fields[0]
+	if ip, err := strconv.ParseUint(host, 10, 32); err == nil {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(ip))
+		host = net.IP(b).String()
+	} else if net.ParseIP(host) == nil {
+		return nil, errors.New("invalid DCC SEND address")
+	}
+
+	filename = filepath.Base(filename)
+	if filename == "." || filename == ".." || filename == string(filepath.Separator) {
+		return nil, errors.New("invalid DCC SEND filename")
+	}
+
+	return &dccSend{
+		Filename: filename,
+		Addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		Size:     size,
+	}, nil
+}
+
+// receive connects to the sender and reads the file.
+func (d *dccSend) receive() ([]byte, error) {
+	if d.Size > maxUploadSize {
+		return nil, fmt.Errorf("file too large (max %d MB)", maxUploadSize/1024/1024)
+	}
+
+	conn, err := net.DialTimeout("tcp", d.Addr, time.Second*30)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	data := make([]byte, 0, d.Size)
+	buf := make([]byte, 32*1024)
+	ack := make([]byte, 4)
+
+	for int64(len(data)) < d.Size {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if int64(len(data)+n) > d.Size {
+				n = int(d.Size) - len(data)
+			}
+
+			data = append(data, buf[:n]...)
+
+			// acknowledge the number of bytes received (the lower 32 bits)
+			binary.BigEndian.PutUint32(ack, uint32(len(data)))
+
+			if _, err := conn.Write(ack); err != nil {
+				return nil, err
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) && int64(len(data)) == d.Size {
+				break
+			}
+
+			return nil, fmt.Errorf("DCC transfer failed after %d of %d bytes: %s", len(data), d.Size, err)
+		}
+	}
+
+	return data, nil
+}
+
+// handleDCCSend uploads a file offered with DCC SEND to toUser.
+func (u *User) handleDCCSend(toUser *User, msg string) {
+	service, ok := u.Srv.HasUser(u.br.Protocol())
+	if !ok {
+		return
+	}
+
+	if !u.v.GetBool(u.br.Protocol() + ".allowdccupload") {
+		u.MsgUser(service, "DCC SEND to "+toUser.Nick+" ignored, DCC uploads are not allowed")
+		return
+	}
+
+	offer, err := parseDCCSend(msg)
+	if err != nil {
+		u.MsgUser(service, "DCC SEND to "+toUser.Nick+" failed: "+err.Error())
+		return
+	}
+
+	data, err := offer.receive()
+	if err != nil {
+		u.MsgUser(service, "DCC SEND to "+toUser.Nick+" failed: "+err.Error())
+		return
+	}
+
+	if _, err := u.br.UploadUser(toUser.User, offer.Filename, data, ""); err != nil {
+		u.MsgUser(service, "upload of "+offer.Filename+" to "+toUser.Nick+" failed: "+err.Error())
+		return
+	}
+
+	u.MsgUser(service, "uploaded "+offer.Filename+" to "+toUser.Nick)
+}
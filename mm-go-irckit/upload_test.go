@@ -0,0 +1,132 @@
+package irckit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDCCSend(t *testing.T) {
+	tests := []struct {
+		Desc   string
+		Value  string
+		Result *dccSend
+		IsGood bool
+	}{
+		{
+			Desc:   "ipv4 as integer",
+			Value:  "\x01DCC SEND crash.log 3232235777 5000 1234\x01",
+			Result: &dccSend{Filename: "crash.log", Addr: "192.168.1.1:5000", Size: 1234},
+			IsGood: true,
+		},
+		{
+			Desc:   "quoted filename",
+			Value:  "\x01DCC SEND \"my crash.log\" 3232235777 5000 1234\x01",
+			Result: &dccSend{Filename: "my crash.log", Addr: "192.168.1.1:5000", Size: 1234},
+			IsGood: true,
+		},
+		{
+			Desc:   "ipv6",
+			Value:  "\x01DCC SEND crash.log ::1 5000 1234\x01",
+			Result: &dccSend{Filename: "crash.log", Addr: "[::1]:5000", Size: 1234},
+			IsGood: true,
+		},
+		{
+			Desc:   "path in filename is stripped",
+			Value:  "\x01DCC SEND ../../etc/passwd 3232235777 5000 1234\x01",
+			Result: &dccSend{Filename: "passwd", Addr: "192.168.1.1:5000", Size: 1234},
+			IsGood: true,
+		},
+		{
+			Desc:  "empty filename",
+			Value: "\x01DCC SEND \"\" 3232235777 5000 1234\x01",
+		},
+		{
+			Desc:  "root as filename",
+			Value: "\x01DCC SEND / 3232235777 5000 1234\x01",
+		},
+		{
+			Desc:  "passive dcc",
+			Value: "\x01DCC SEND crash.log 3232235777 0 1234 42\x01",
+		},
+		{
+			Desc:  "invalid address",
+			Value: "\x01DCC SEND crash.log example.com 5000 1234\x01",
+		},
+		{
+			Desc:  "missing size",
+			Value: "\x01DCC SEND crash.log 3232235777 5000\x01",
+		},
+	}
+
+	for _, tc := range tests {
+		result, err := parseDCCSend(tc.Value)
+		if tc.IsGood {
+			assert.NoError(t, err, tc.Desc)
+		} else {
+			assert.Error(t, err, tc.Desc)
+		}
+		assert.Equal(t, tc.Result, result, tc.Desc)
+	}
+}
+
+// uploadBridge uploads to channels after release is closed.
+type uploadBridge struct {
+	fakeBridge
+
+	release  chan struct{}
+	uploaded chan string
+}
+
+func (b *uploadBridge) GetMe() *bridge.UserInfo {
+	return &bridge.UserInfo{TeamID: "team"}
+}
+
+func (b *uploadBridge) GetChannelID(name, teamID string) string {
+	return name + "id"
+}
+
+func (b *uploadBridge) UploadChannel(channelID, filename string, data []byte, caption string) (string, error) {
+	<-b.release
+	b.uploaded <- channelID + " " + filename + " " + string(data) + " " + caption
+
+	return "file1", nil
+}
+
+func TestUploadInBackground(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "crash.log"), []byte("panic"), 0o600))
+
+	br := &uploadBridge{release: make(chan struct{}), uploaded: make(chan string, 1)}
+	conn := &recordConn{}
+
+	u := newBridgeUser(br)
+	u.Conn = conn
+	u.v.Set("mattermost.UploadDir", dir)
+
+	svc := &User{UserInfo: &bridge.UserInfo{Nick: "mattermost", User: "mattermost", Host: "service"}}
+
+	// returns while the upload is still in progress
+	upload(u, svc, []string{"#bugs", "crash.log", "the", "log"}, "mattermost")
+	close(br.release)
+
+	select {
+	case uploaded := <-br.uploaded:
+		assert.Equal(t, "bugsid crash.log panic the log", uploaded)
+	case <-time.After(time.Second):
+		t.Fatal("not uploaded")
+	}
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"uploaded crash.log to #bugs"}, conn.texts())
+	}, time.Second, 10*time.Millisecond)
+}
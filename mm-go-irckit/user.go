@@ -234,12 +234,14 @@ func (u *User) Decode() {
 		if msg.Command == "PRIVMSG" && msg.Params != nil && (msg.Params[0] == "slack" || msg.Params[0] == "mattermost") {
 			// Don't log sensitive information
 			trail := strings.Split(msg.Trailing, " ")
-			if (msg.Trailing != "" && trail[0] == "login") || (len(msg.Params) > 1 && msg.Params[1] == "login") {
-				dmsg = fmt.Sprintf("<- PRIVMSG %s :login [redacted]", msg.Params[0])
+			for _, cmd := range []string{"login", "mfa"} {
+				if (msg.Trailing != "" && trail[0] == cmd) || (len(msg.Params) > 1 && msg.Params[1] == cmd) {
+					dmsg = fmt.Sprintf("<- PRIVMSG %s :%s [redacted]", msg.Params[0], cmd)
+				}
 			}
 		}
-		// PRIVMSG can be buffered
-		if msg.Command == "PRIVMSG" {
+		// PRIVMSG can be buffered (except DCC offers)
+		if msg.Command == "PRIVMSG" && !strings.HasPrefix(msg.Trailing, "\x01DCC ") {
 			logger.Debugf("B: %#v\n", dmsg)
 			buffer <- msg
 		} else {
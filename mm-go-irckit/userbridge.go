@@ -1,12 +1,9 @@
 package irckit
 
 import (
-	"encoding/gob"
-	"errors"
 	"fmt"
 	"math/rand"
 	"net"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -14,6 +11,7 @@ import (
 	"github.com/42wim/matterircd/bridge"
 	"github.com/42wim/matterircd/bridge/mattermost"
 	"github.com/42wim/matterircd/bridge/slack"
+	"github.com/42wim/matterircd/pkg/emoji"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/muesli/reflow/wordwrap"
@@ -24,24 +22,47 @@ import (
 type UserBridge struct {
 	Srv         Server
 	Credentials bridge.Credentials
-	br          bridge.Bridger     //nolint:structcheck
-	inprogress  bool               //nolint:structcheck
-	eventChan   chan *bridge.Event //nolint:structcheck
+	br          bridge.Bridger      //nolint:structcheck
+	inprogress  bool                //nolint:structcheck
+	configLogin bool                //nolint:structcheck
+	pendingMFA  *bridge.Credentials //nolint:structcheck
+	awayStatus  bool                //nolint:structcheck
+	eventChan   chan *bridge.Event  //nolint:structcheck
+	// eventMutex is held while logging in, events wait until our state is loaded
+	eventMutex sync.Mutex //nolint:structcheck
 
 	lastViewedAtMutex sync.RWMutex     //nolint:structcheck
 	lastViewedAt      map[string]int64 //nolint:structcheck
 
-	lastViewedAtSaved int64          //nolint:structcheck
-	msgCounter        map[string]int //nolint:structcheck
+	stateMutex     sync.Mutex     //nolint:structcheck
+	statePath      string         //nolint:structcheck
+	stateLoaded    bool           //nolint:structcheck
+	stateSaved     int64          //nolint:structcheck
+	stateCreatedAt int64          //nolint:structcheck
+	msgCounter     map[string]int //nolint:structcheck
 
 	msgLastMutex sync.RWMutex         //nolint:structcheck
 	msgLast      map[string][2]string //nolint:structcheck
 
 	msgMapMutex sync.RWMutex              //nolint:structcheck
 	msgMap      map[string]map[string]int //nolint:structcheck
+	// msgMapIndex is the reverse of msgMap (counter to message ID)
+	msgMapIndex map[string]map[int]string //nolint:structcheck
 
 	updateCounterMutex sync.Mutex           //nolint:structcheck
 	updateCounter      map[string]time.Time //nolint:structcheck
+
+	followedThreadsMutex sync.Mutex           //nolint:structcheck
+	followedThreads      []*bridge.ThreadInfo //nolint:structcheck
+	// unfollowedThreads are the root post IDs of the thread channels we parted,
+	// with the time (in milliseconds) we parted them.
+	unfollowedThreads map[string]int64 //nolint:structcheck
+
+	searchMutex   sync.Mutex    //nolint:structcheck
+	searchResults []*model.Post //nolint:structcheck
+
+	archiveMutex  sync.Mutex     //nolint:structcheck
+	archiveWriter *archiveWriter //nolint:structcheck
 }
 
 func NewUserBridge(c net.Conn, srv Server, cfg *viper.Viper) *User {
@@ -53,10 +74,12 @@ func NewUserBridge(c net.Conn, srv Server, cfg *viper.Viper) *User {
 
 	u.Srv = srv
 	u.v = cfg
-	u.lastViewedAt = u.loadLastViewedAt()
+	u.lastViewedAt = make(map[string]int64)
 	u.msgLast = make(map[string][2]string)
 	u.msgMap = make(map[string]map[string]int)
+	u.msgMapIndex = make(map[string]map[int]string)
 	u.msgCounter = make(map[string]int)
+	u.unfollowedThreads = make(map[string]int64)
 	u.updateCounter = make(map[string]time.Time)
 	u.eventChan = make(chan *bridge.Event, 1000)
 
@@ -70,6 +93,11 @@ func NewUserBridge(c net.Conn, srv Server, cfg *viper.Viper) *User {
 func (u *User) handleEventChan() {
 	for event := range u.eventChan {
 		logger.Tracef("eventchan %s", spew.Sdump(event))
+
+		u.eventMutex.Lock()
+
+		u.archiveEvent(event)
+
 		switch e := event.Data.(type) {
 		case *bridge.ChannelMessageEvent:
 			u.handleChannelMessageEvent(e)
@@ -87,18 +115,27 @@ func (u *User) handleEventChan() {
 			u.handleChannelCreateEvent(e)
 		case *bridge.ChannelDeleteEvent:
 			u.handleChannelDeleteEvent(e)
+		case *bridge.ChannelMuteEvent:
+			u.handleChannelMuteEvent(e)
+		case *bridge.ChannelUpdateEvent:
+			u.handleChannelUpdateEvent(e)
 		case *bridge.UserUpdateEvent:
 			u.handleUserUpdateEvent(e)
 		case *bridge.StatusChangeEvent:
 			u.handleStatusChangeEvent(e)
 		case *bridge.ReactionAddEvent, *bridge.ReactionRemoveEvent:
 			u.handleReactionEvent(e)
+		case *bridge.MFARequiredEvent:
+			u.handleMFARequiredEvent()
+		case *bridge.ThreadUpdateEvent:
+			u.handleThreadUpdateEvent(e)
 		case *bridge.LogoutEvent:
-			if statePath := u.v.GetString(u.br.Protocol() + ".lastviewedsavefile"); statePath != "" {
-				saveLastViewedAtStateFile(statePath, u.lastViewedAt)
-			}
+			u.saveState()
+			u.eventMutex.Unlock()
 			return
 		}
+
+		u.eventMutex.Unlock()
 	}
 }
 
@@ -133,7 +170,10 @@ func (u *User) handleDirectMessageEvent(event *bridge.DirectMessageEvent) {
 		}
 	}
 
-	if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+	event.Text = u.incomingMentions(event.Text)
+
+	// ephemeral messages don't have a (real) message to refer to
+	if (u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext")) && event.MessageID != "" {
 		prefixUser := event.Sender.User
 
 		if event.Sender.Me {
@@ -218,19 +258,19 @@ func (u *User) getMessageChannel(channelID string, sender *bridge.UserInfo) Chan
 	ch := u.Srv.Channel(channelID)
 	ghost := u.createUserFromInfo(sender)
 
-	// if it's another user, let them join
-	if !ghost.Me && !ch.HasUser(ghost) {
+	// if it's another user, let them join (the service bot only talks)
+	if !ghost.Me && ghost.Host != "service" && !ch.HasUser(ghost) {
 		logger.Debugf("User %s is not in channel %s. Joining now", ghost.Nick, ch.String())
 		ch.Join(ghost)
 	}
 
+	// if we are on it, just return it
+	if ch.HasUser(u) {
+		return ch
+	}
+
 	// check if we mayjoin this channel
 	if u.mayJoin(channelID) {
-		// if we are on it, just return it
-		if ch.HasUser(u) {
-			return ch
-		}
-
 		// otherwise first sync it
 		u.syncChannel(channelID, u.br.GetChannelName(channelID))
 
@@ -240,6 +280,30 @@ func (u *User) getMessageChannel(channelID string, sender *bridge.UserInfo) Chan
 	return u.Srv.Channel("&messages")
 }
 
+// getThreadChannel returns the thread channel of rootID in channelID, it gets
+// joined when we're not on it yet.
+func (u *User) getThreadChannel(channelID, rootID string, sender *bridge.UserInfo) Channel {
+	threadID := bridge.ThreadChannelID(channelID, rootID)
+	ch := u.Srv.Channel(threadID)
+
+	if !ch.HasUser(u) {
+		logger.Debugf("joining thread channel %s (id: %s)", ch.String(), threadID)
+		ch.Join(u)
+
+		svc, _ := u.Srv.HasUser(u.br.Protocol())
+		ch.Topic(svc, u.br.Topic(threadID))
+	}
+
+	// add the participants of the thread
+	if !sender.Me {
+		if ghost, ok := u.Srv.HasUser(sanitizeNick(sender.Nick)); ok && ghost.Ghost && !ch.HasUser(ghost) {
+			ch.Join(ghost)
+		}
+	}
+
+	return ch
+}
+
 func (u *User) handleChannelMessageEvent(event *bridge.ChannelMessageEvent) {
 	/*
 		CHANNEL_OPEN                   = "O"
@@ -247,9 +311,19 @@ func (u *User) handleChannelMessageEvent(event *bridge.ChannelMessageEvent) {
 		CHANNEL_DIRECT                 = "D"
 		CHANNEL_GROUP                  = "G"
 	*/
+	thread := u.showInThreadChannel(event.Thread, event.ParentID, event.Sender)
+
+	if !thread && u.hideMuted(event.ChannelID, event.Text) {
+		logger.Debugf("not showing message of muted channel %s", event.ChannelID)
+		return
+	}
+
 	nick := sanitizeNick(event.Sender.Nick)
 	logger.Debug("in handleChannelMessageEvent")
 	ch := u.getMessageChannel(event.ChannelID, event.Sender)
+	if thread {
+		ch = u.getThreadChannel(event.ChannelID, event.ParentID, event.Sender)
+	}
 	if event.Sender.Me {
 		nick = u.Nick
 	}
@@ -270,7 +344,10 @@ func (u *User) handleChannelMessageEvent(event *bridge.ChannelMessageEvent) {
 		}
 	}
 
-	if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+	event.Text = u.incomingMentions(event.Text)
+
+	// ephemeral messages don't have a (real) message to refer to
+	if (u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext")) && event.MessageID != "" {
 		prefix := u.prefixContext(event.ChannelID, event.MessageID, event.ParentID, event.Event)
 		switch {
 		case u.v.GetBool(u.br.Protocol()+".prefixcontext") && strings.HasPrefix(event.Text, "\x01"):
@@ -318,6 +395,10 @@ func (u *User) handleFileEvent(event *bridge.FileEvent) {
 			}
 		default:
 			ch := u.getMessageChannel(event.ChannelID, event.Sender)
+			if u.showInThreadChannel(event.Thread, event.ParentID, event.Sender) {
+				ch = u.getThreadChannel(event.ChannelID, event.ParentID, event.Sender)
+			}
+
 			if event.Sender.Me {
 				ch.SpoofMessage(u.Nick, fileMsg)
 			} else {
@@ -335,12 +416,161 @@ func (u *User) handleChannelCreateEvent(event *bridge.ChannelCreateEvent) {
 	u.syncChannel(event.ChannelID, u.br.GetChannelName(event.ChannelID))
 }
 
+// createGroup creates (or reuses) a group message channel with nicks and joins
+// it, returning the channel name.
+func (u *User) createGroup(nicks []string) (string, error) {
+	var userIDs []string
+
+	for _, nick := range nicks {
+		if nick == "" {
+			continue
+		}
+
+		other, ok := u.Srv.HasUser(nick)
+		if !ok || !other.Ghost {
+			return "", fmt.Errorf("user %s does not exist", nick)
+		}
+
+		userIDs = append(userIDs, other.User)
+	}
+
+	channelID, err := u.br.CreateGroup(userIDs)
+	if err != nil {
+		return "", err
+	}
+
+	// an existing group doesn't get a group_added event
+	u.handleChannelCreateEvent(&bridge.ChannelCreateEvent{ChannelID: channelID})
+
+	return u.br.GetChannelName(channelID), nil
+}
+
 func (u *User) handleChannelDeleteEvent(event *bridge.ChannelDeleteEvent) {
 	ch := u.Srv.Channel(event.ChannelID)
 
 	logger.Debugf("ACTION_CHANNEL_DELETED removing myself from %s (%s)", u.br.GetChannelName(event.ChannelID), event.ChannelID)
 
-	ch.Part(u, "")
+	reason := ""
+	if u.br.IsArchived(event.ChannelID) {
+		reason = "channel archived, JOIN it again to read it"
+	}
+
+	ch.Part(u, reason)
+}
+
+// archivedScrollback is the number of messages shown when joining an archived channel.
+const archivedScrollback = 50
+
+// showArchived shows the last messages of the archived (read-only) channelID.
+func (u *User) showArchived(channelID string) {
+	ch := u.Srv.Channel(channelID)
+	ch.SpoofMessage("matterircd", "\x02This channel is archived and read-only, use SCROLLBACK for older messages\x0f")
+
+	postlist, ok := u.br.GetPosts(channelID, archivedScrollback).(*model.PostList)
+	if !ok || postlist == nil {
+		return
+	}
+
+	var posts []*model.Post
+
+	for i := len(postlist.Order) - 1; i >= 0; i-- {
+		posts = append(posts, postlist.Posts[postlist.Order[i]])
+	}
+
+	u.showPosts(posts, channelID, channelID, nil)
+}
+
+// handleChannelUpdateEvent moves a renamed channel to its new name (keeping its
+// members) and updates its private mode and topic.
+func (u *User) handleChannelUpdateEvent(event *bridge.ChannelUpdateEvent) {
+	ch, ok := u.Srv.HasChannel(event.ChannelID)
+	if !ok {
+		return
+	}
+
+	if name := u.br.GetChannelName(event.ChannelID); name != "" && name != ch.String() {
+		logger.Debugf("channel %s renamed to %s", ch.String(), name)
+		ch = u.renameChannel(ch, name)
+	}
+
+	// the changes are made by the service user if we have one
+	var from Prefixer = ch
+	if svc, ok := u.Srv.HasUser(u.br.Protocol()); ok {
+		from = svc
+	}
+
+	if info, err := u.br.GetChannel(event.ChannelID); err == nil {
+		ch.SetPrivate(from, info.Private)
+	}
+
+	ch.Topic(from, u.br.Topic(event.ChannelID))
+}
+
+// renameChannel replaces channel old by a channel with the new name, it's
+// parted and joined again when we're on it. Its thread channels are renamed
+// too.
+func (u *User) renameChannel(old Channel, name string) Channel {
+	ch := u.replaceChannel(old, name)
+
+	for _, thread := range u.Srv.Channels() {
+		if parentID, _, ok := bridge.ParseThreadChannelID(thread.ID()); ok && parentID == old.ID() {
+			u.replaceChannel(thread, u.br.GetChannelName(thread.ID()))
+		}
+	}
+
+	return ch
+}
+
+// replaceChannel replaces channel old by a channel with the new name, with the
+// same ghosts.
+func (u *User) replaceChannel(old Channel, name string) Channel {
+	joined := old.HasUser(u)
+	if joined {
+		old.Part(u, "renamed to "+name)
+	}
+
+	var ghosts []*User
+
+	for _, user := range old.Users() {
+		if user.Ghost {
+			ghosts = append(ghosts, user)
+			old.Part(user, "")
+		}
+	}
+
+	old.Unlink()
+
+	ch := u.Srv.Channel(old.ID())
+	ch.Topic(old, old.GetTopic())
+	ch.BatchJoin(ghosts)
+
+	if joined {
+		ch.Join(u)
+	}
+
+	return ch
+}
+
+// handleChannelMuteEvent parts channels that get muted and joins them again
+// when unmuted (MutedChannels).
+func (u *User) handleChannelMuteEvent(event *bridge.ChannelMuteEvent) {
+	if u.v.GetString(u.br.Protocol()+".mutedchannels") == "" {
+		return
+	}
+
+	ch := u.Srv.Channel(event.ChannelID)
+
+	if event.Muted {
+		if ch.HasUser(u) {
+			logger.Debugf("channel %s muted, parting", ch.String())
+			ch.Part(u, "muted")
+		}
+
+		return
+	}
+
+	logger.Debugf("channel %s unmuted, joining", ch.String())
+	u.syncChannel(event.ChannelID, u.br.GetChannelName(event.ChannelID))
 }
 
 func (u *User) handleUserUpdateEvent(event *bridge.UserUpdateEvent) {
@@ -353,6 +583,9 @@ func (u *User) handleStatusChangeEvent(event *bridge.StatusChangeEvent) {
 		case "online":
 			logger.Debug("setting myself online")
 			u.Srv.EncodeMessage(u, irc.RPL_UNAWAY, []string{u.Nick}, "You are no longer marked as being away")
+		case "dnd":
+			logger.Debug("setting myself dnd")
+			u.Srv.EncodeMessage(u, irc.RPL_NOWAWAY, []string{u.Nick}, "You have been marked as being away (do not disturb)")
 		default:
 			logger.Debug("setting myself away")
 			u.Srv.EncodeMessage(u, irc.RPL_NOWAWAY, []string{u.Nick}, "You have been marked as being away")
@@ -360,6 +593,16 @@ func (u *User) handleStatusChangeEvent(event *bridge.StatusChangeEvent) {
 	}
 }
 
+func (u *User) handleMFARequiredEvent() {
+	service, ok := u.Srv.HasUser(u.br.Protocol())
+	if !ok {
+		return
+	}
+
+	u.MsgUser(service, "session expired, reconnecting needs a new MFA token")
+	u.MsgUser(service, "use MFA <token> to continue, e.g. MFA 123456")
+}
+
 func (u *User) handleReactionEvent(event interface{}) {
 	var (
 		text, channelID, messageID, channelType, reaction string
@@ -401,6 +644,14 @@ func (u *User) handleReactionEvent(event interface{}) {
 
 	defer u.saveLastViewedAt(channelID)
 
+	if u.v.GetBool(u.br.Protocol() + ".unicode") {
+		if e, ok := emoji.Unicode(reaction); ok {
+			reaction = e
+		} else {
+			reaction = ":" + reaction + ":"
+		}
+	}
+
 	if u.v.GetBool(u.br.Protocol() + ".hidereactions") {
 		logger.Debug("Not showing reaction: " + text + reaction)
 		return
@@ -509,8 +760,8 @@ func (u *User) addUsersToChannel(users []*User, channel string, channelID string
 }
 
 func (u *User) addUsersToChannels() {
-	// wait until the bridge is ready
-	for u.br == nil {
+	// wait until the bridge and our state are ready
+	for u.br == nil || !u.stateLoaded {
 		logger.Debug("bridge not ready yet, sleeping")
 		time.Sleep(time.Millisecond * 500)
 	}
@@ -534,6 +785,17 @@ func (u *User) addUsersToChannels() {
 	ch = srv.Channel("&messages")
 	ch.Join(u)
 
+	// channel that shows the threads we follow with unread replies
+	if u.v.GetBool(u.br.Protocol() + ".threadschannel") {
+		srv.Channel("&threads").Join(u)
+
+		go func() {
+			if err := u.listThreads(nil, true); err != nil {
+				logger.Errorf("listing threads failed: %s", err)
+			}
+		}()
+	}
+
 	channels := make(chan *bridge.ChannelInfo, 5)
 	for i := 0; i < 10; i++ {
 		go u.addUserToChannelWorker(channels, throttle)
@@ -581,6 +843,66 @@ func (u *User) createSpoof(mmchannel *bridge.ChannelInfo) func(string, string) {
 	return ch.SpoofMessage
 }
 
+// mutedRouting returns how messages of channelID are shown when it's muted:
+// "messages" (in &messages) or "hide", "" for channels that aren't muted.
+func (u *User) mutedRouting(channelID string) string {
+	routing := u.v.GetString(u.br.Protocol() + ".mutedchannels")
+	if routing == "" || !u.br.IsMuted(channelID) {
+		return ""
+	}
+
+	return routing
+}
+
+// hideMuted returns true if a message with text of channelID shouldn't be shown
+// because the channel is muted and we're not on it. Mentions are always shown.
+func (u *User) hideMuted(channelID, text string) bool {
+	if u.mutedRouting(channelID) != "hide" {
+		return false
+	}
+
+	if ch, ok := u.Srv.HasChannel(channelID); ok && ch.HasUser(u) {
+		return false
+	}
+
+	for _, m := range u.MentionKeys {
+		if m != "" && strings.Contains(text, m) {
+			return false
+		}
+	}
+
+	return u.Username == "" || !strings.Contains(text, "@"+u.Username)
+}
+
+// emojiText replaces emoji shortcodes in text by unicode emoji when the
+// unicode option is enabled.
+func (u *User) emojiText(text string) string {
+	if !u.v.GetBool(u.br.Protocol() + ".unicode") {
+		return text
+	}
+
+	return emoji.ToUnicode(text)
+}
+
+// contextChannelID returns the key of the channel in msgMap, for direct
+// messages this is the user ID of the other side.
+func (u *User) contextChannelID(brchannel *bridge.ChannelInfo) string {
+	if !strings.Contains(brchannel.Name, "__") {
+		return brchannel.ID
+	}
+
+	me := u.br.GetMe().User
+	contextID := me
+
+	for _, userID := range strings.Split(brchannel.Name, "__") {
+		if userID != me {
+			contextID = userID
+		}
+	}
+
+	return contextID
+}
+
 func (u *User) addUserToChannelWorker(channels <-chan *bridge.ChannelInfo, throttle *time.Ticker) {
 	for brchannel := range channels {
 		logger.Debug("addUserToChannelWorker", brchannel)
@@ -588,6 +910,7 @@ func (u *User) addUserToChannelWorker(channels <-chan *bridge.ChannelInfo, throt
 		<-throttle.C
 		// exclude direct messages
 		spoof := u.createSpoof(brchannel)
+		contextID := u.contextChannelID(brchannel)
 
 		since := u.br.GetLastViewedAt(brchannel.ID)
 		// ignore invalid/deleted/old channels
@@ -606,10 +929,7 @@ func (u *User) addUserToChannelWorker(channels <-chan *bridge.ChannelInfo, throt
 		// post everything to the channel you haven't seen yet
 		postlist := u.br.GetPostsSince(brchannel.ID, since)
 		if postlist == nil {
-			// if the channel is not from the primary team id, we can't get posts
-			if brchannel.TeamID == u.br.GetMe().TeamID {
-				logger.Errorf("something wrong with getPostsSince for channel %s (%s)", brchannel.ID, brchannel.Name)
-			}
+			logger.Errorf("something wrong with getPostsSince for channel %s (%s)", brchannel.ID, brchannel.Name)
 			continue
 		}
 
@@ -650,7 +970,7 @@ func (u *User) addUserToChannelWorker(channels <-chan *bridge.ChannelInfo, throt
 			}
 
 			codeBlock := false
-			for _, post := range strings.Split(p.Message, "\n") {
+			for _, post := range strings.Split(u.incomingMentions(u.emojiText(p.Message)), "\n") {
 				if post == "```" {
 					codeBlock = !codeBlock
 				}
@@ -673,8 +993,8 @@ func (u *User) addUserToChannelWorker(channels <-chan *bridge.ChannelInfo, throt
 				}
 
 				replayMsg := fmt.Sprintf("[%s] %s", ts.Format("15:04"), post)
-				if (u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext")) && u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost" {
-					threadMsgID := u.prefixContext("", p.Id, p.ParentId, "")
+				if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+					threadMsgID := u.prefixContext(contextID, p.Id, p.ParentId, "")
 					replayMsg = u.formatContextMessage(ts.Format("15:04"), threadMsgID, post)
 				}
 				spoof(nick, replayMsg)
@@ -686,8 +1006,8 @@ func (u *User) addUserToChannelWorker(channels <-chan *bridge.ChannelInfo, throt
 
 			for _, fname := range u.br.GetFileLinks(p.FileIds) {
 				fileMsg := "download file - " + fname
-				if u.v.GetString(u.br.Protocol()+".threadcontext") == "mattermost" {
-					threadMsgID := u.prefixContext("", p.Id, p.ParentId, "")
+				if u.v.GetBool(u.br.Protocol()+".prefixcontext") || u.v.GetBool(u.br.Protocol()+".suffixcontext") {
+					threadMsgID := u.prefixContext(contextID, p.Id, p.ParentId, "")
 					fileMsg = u.formatContextMessage(ts.Format("15:04"), threadMsgID, fileMsg)
 				}
 				spoof(nick, fileMsg)
@@ -757,18 +1077,24 @@ func (u *User) syncChannel(id string, name string) {
 func (u *User) mayJoin(channelID string) bool {
 	ch := u.Srv.Channel(channelID)
 
+	// muted channels are only joined explicitly
+	if u.mutedRouting(channelID) != "" {
+		logger.Tracef("mayjoin %t ch: %s, muted", false, ch.String())
+		return false
+	}
+
 	jo := u.v.GetStringSlice(u.br.Protocol() + ".joinonly")
 	ji := u.v.GetStringSlice(u.br.Protocol() + ".joininclude")
 	je := u.v.GetStringSlice(u.br.Protocol() + ".joinexclude")
 
 	switch {
 	// if we have joinonly channels specified we are only allowed to join those
-	case len(jo) != 0 && !stringInRegexp(ch.String(), jo):
+	case len(jo) != 0 && !u.matchJoinRules(channelID, ch.String(), jo):
 		logger.Tracef("mayjoin 0 %t ch: %s, match: %s", false, ch.String(), jo)
 		return false
 	// we only have exclude, do not join if in exclude
 	case len(ji) == 0 && len(je) != 0:
-		mayjoin := !stringInRegexp(ch.String(), je)
+		mayjoin := !u.matchJoinRules(channelID, ch.String(), je)
 		logger.Tracef("mayjoin 1 %t ch: %s, match: %s", mayjoin, ch.String(), je)
 		return mayjoin
 	// nothing specified, everything may join
@@ -777,13 +1103,13 @@ func (u *User) mayJoin(channelID string) bool {
 		return true
 	// if we don't have joinexclude, then joininclude behaves as joinonly
 	case len(ji) != 0 && len(je) == 0:
-		mayjoin := stringInRegexp(ch.String(), ji)
+		mayjoin := u.matchJoinRules(channelID, ch.String(), ji)
 		logger.Tracef("mayjoin 3 %t ch: %s, match: %s", mayjoin, ch.String(), ji)
 		return mayjoin
 	// joininclude overrides the joinexclude
 	case len(ji) != 0 && len(je) != 0:
 		// if explicit in ji we also may join
-		mayjoin := stringInRegexp(ch.String(), ji)
+		mayjoin := u.matchJoinRules(channelID, ch.String(), ji)
 		logger.Tracef("mayjoin 4 %t ch: %s, match: %s", mayjoin, ch.String(), ji)
 		return mayjoin
 	}
@@ -793,6 +1119,26 @@ func (u *User) mayJoin(channelID string) bool {
 	return false
 }
 
+// matchJoinRules returns true if channel name matches one of the JoinOnly,
+// JoinInclude or JoinExclude rules: a regexp or category:<sidebar category>.
+func (u *User) matchJoinRules(channelID, name string, rules []string) bool {
+	var regexps []string
+
+	for _, rule := range rules {
+		category := strings.TrimPrefix(rule, "category:")
+		if category == rule {
+			regexps = append(regexps, rule)
+			continue
+		}
+
+		if strings.EqualFold(u.br.GetCategory(channelID), category) {
+			return true
+		}
+	}
+
+	return stringInRegexp(name, regexps)
+}
+
 func (u *User) isValidServer(server, protocol string) bool {
 	if len(u.v.GetStringSlice(protocol+".restrict")) == 0 {
 		return true
@@ -812,6 +1158,29 @@ func (u *User) isValidServer(server, protocol string) bool {
 func (u *User) loginTo(protocol string) error {
 	var err error
 
+	// events handled before our state is loaded would get context IDs the
+	// state overwrites
+	u.eventMutex.Lock()
+	defer u.eventMutex.Unlock()
+
+	// our state gets loaded after login
+	u.stateLoaded = false
+	u.statePath = ""
+
+	u.lastViewedAtMutex.Lock()
+	u.lastViewedAt = make(map[string]int64)
+	u.lastViewedAtMutex.Unlock()
+
+	u.msgMapMutex.Lock()
+	u.msgMap = make(map[string]map[string]int)
+	u.msgMapIndex = make(map[string]map[int]string)
+	u.msgCounter = make(map[string]int)
+	u.msgMapMutex.Unlock()
+
+	u.followedThreadsMutex.Lock()
+	u.unfollowedThreads = make(map[string]int64)
+	u.followedThreadsMutex.Unlock()
+
 	switch protocol {
 	case "slack":
 		u.br, err = slack.New(u.v, u.Credentials, u.eventChan, u.addUsersToChannels)
@@ -823,8 +1192,10 @@ func (u *User) loginTo(protocol string) error {
 		return err
 	}
 
+	u.loadState()
+
 	status, _ := u.br.StatusUser(u.br.GetMe().User)
-	if status == "away" {
+	if status == "away" || status == "dnd" {
 		u.Srv.EncodeMessage(u, irc.RPL_NOWAWAY, []string{u.Nick}, "You have been marked as being away")
 	}
 
@@ -841,23 +1212,45 @@ func (u *User) logoutFrom(protocol string) error {
 	logger.Debug("logging out from", protocol)
 
 	u.Srv.Logout(u)
-	if statePath := u.v.GetString(u.br.Protocol() + ".lastviewedsavefile"); statePath != "" {
-		saveLastViewedAtStateFile(statePath, u.lastViewedAt)
-	}
+	u.saveState()
+	u.closeArchive()
 	return nil
 }
 
+// maxMsgCounter is the number of context IDs per channel, after that the counter wraps.
+const maxMsgCounter = 4095
+
 func (u *User) increaseMsgCounter(channelID string) int {
 	u.msgCounter[channelID]++
 
 	// max 4096 entries
-	if u.msgCounter[channelID] == 4095 {
+	if u.msgCounter[channelID] == maxMsgCounter {
 		u.msgCounter[channelID] = 0
 	}
 
+	// the counter is reused, forget the message that had it before
+	if messageID, ok := u.msgMapIndex[channelID][u.msgCounter[channelID]]; ok {
+		delete(u.msgMap[channelID], messageID)
+		delete(u.msgMapIndex[channelID], u.msgCounter[channelID])
+	}
+
 	return u.msgCounter[channelID]
 }
 
+// setMsgMap remembers counter as the context ID of messageID.
+func (u *User) setMsgMap(channelID, messageID string, counter int) {
+	if _, ok := u.msgMap[channelID]; !ok {
+		u.msgMap[channelID] = make(map[string]int)
+	}
+
+	if _, ok := u.msgMapIndex[channelID]; !ok {
+		u.msgMapIndex[channelID] = make(map[int]string)
+	}
+
+	u.msgMap[channelID][messageID] = counter
+	u.msgMapIndex[channelID][counter] = messageID
+}
+
 func (u *User) formatContextMessage(ts, threadMsgID, msg string) string {
 	var formattedMsg string
 	switch {
@@ -920,20 +1313,21 @@ func (u *User) prefixContext(channelID, messageID, parentID, event string) strin
 		}
 
 		if _, ok = u.msgMap[channelID][parentID]; !ok {
-			u.increaseMsgCounter(channelID)
-			u.msgMap[channelID][parentID] = u.msgCounter[channelID]
+			u.setMsgMap(channelID, parentID, u.increaseMsgCounter(channelID))
 		}
 
 		parentcount = u.msgMap[channelID][parentID]
 	}
 
-	currentcount = u.increaseMsgCounter(channelID)
-
 	if _, ok = u.msgMap[channelID]; !ok {
 		u.msgMap[channelID] = make(map[string]int)
 	}
 
-	u.msgMap[channelID][messageID] = u.msgCounter[channelID]
+	// reuse the context ID of messages we already know (eg replayed messages)
+	if currentcount, ok = u.msgMap[channelID][messageID]; !ok {
+		currentcount = u.increaseMsgCounter(channelID)
+		u.setMsgMap(channelID, messageID, currentcount)
+	}
 
 	if parentID != "" {
 		return fmt.Sprintf("[%03x->%03x]", currentcount, parentcount)
@@ -960,137 +1354,3 @@ func (u *User) updateLastViewed(channelID string) {
 		u.br.UpdateLastViewed(channelID)
 	}()
 }
-
-func (u *User) loadLastViewedAt() map[string]int64 {
-	statePath := u.v.GetString("mattermost.lastviewedsavefile")
-	if statePath == "" {
-		return make(map[string]int64)
-	}
-
-	if _, err := os.Stat(statePath); os.IsNotExist(err) {
-		logger.Debug("No saved lastViewedAt, using empty values")
-		lastViewedAt := make(map[string]int64)
-		// We also want to dump out/create the lastViewedAt state file.
-		saveLastViewedAtStateFile(statePath, lastViewedAt)
-		return lastViewedAt
-	}
-
-	staleDuration := u.v.GetString("mattermost.lastviewedstaleduration")
-	lastViewedAt, err := loadLastViewedAtStateFile(statePath, staleDuration)
-	if err != nil {
-		logger.Warning("Unable to load saved lastViewedAt, using empty values: ", err)
-		return make(map[string]int64)
-	}
-
-	logger.Info("Loaded lastViewedAt from ", time.Unix(lastViewedAt["__LastViewedStateSavedTime__"]/1000, 0))
-	u.lastViewedAtSaved = model.GetMillis()
-
-	return lastViewedAt
-}
-
-const defaultSaveInterval = int64((5 * time.Minute) / time.Millisecond)
-
-func (u *User) saveLastViewedAt(channelID string) {
-	u.lastViewedAtMutex.Lock()
-	defer u.lastViewedAtMutex.Unlock()
-	if channelID != "" {
-		u.lastViewedAt[channelID] = model.GetMillis()
-	}
-
-	statePath := u.v.GetString(u.br.Protocol() + ".lastviewedsavefile")
-	if statePath == "" {
-		return
-	}
-
-	// We only want to save or dump out saved lastViewedAt on new
-	// messages after X time.
-	var saveInterval int64
-	val, err := time.ParseDuration(u.v.GetString(u.br.Protocol() + ".lastviewedsaveinterval"))
-	if err != nil {
-		saveInterval = defaultSaveInterval
-	} else {
-		saveInterval = val.Milliseconds()
-	}
-	if u.lastViewedAtSaved < (model.GetMillis() - saveInterval) {
-		saveLastViewedAtStateFile(statePath, u.lastViewedAt)
-		u.lastViewedAtSaved = model.GetMillis()
-	}
-}
-
-const lastViewedStateFormat = int64(1)
-
-func saveLastViewedAtStateFile(statePath string, lastViewedAt map[string]int64) error {
-	f, err := os.Create(statePath)
-	if err != nil {
-		logger.Debug("Unable to save lastViewedAt: ", err)
-		return err
-	}
-	defer f.Close()
-
-	currentTime := model.GetMillis()
-
-	lastViewedAt["__LastViewedStateFormat__"] = lastViewedStateFormat
-	if _, ok := lastViewedAt["__LastViewedStateCreateTime__"]; !ok {
-		lastViewedAt["__LastViewedStateCreateTime__"] = currentTime
-	}
-	lastViewedAt["__LastViewedStateSavedTime__"] = currentTime
-	// Simple checksum
-	lastViewedAt["__LastViewedStateChecksum__"] = lastViewedAt["__LastViewedStateCreateTime__"] ^ currentTime
-
-	logger.Debug("Saving lastViewedAt")
-
-	if err := gob.NewEncoder(f).Encode(lastViewedAt); err != nil {
-		return fmt.Errorf("gob encoding failed: %s", err)
-	}
-
-	return nil
-}
-
-const defaultStaleDuration = int64((30 * 24 * time.Hour) / time.Millisecond)
-
-func loadLastViewedAtStateFile(statePath string, staleDuration string) (map[string]int64, error) {
-	f, err := os.Open(statePath)
-	if err != nil {
-		logger.Debug("Unable to load lastViewedAt: ", err)
-		return nil, err
-	}
-	defer f.Close()
-
-	var lastViewedAt map[string]int64
-	err = gob.NewDecoder(f).Decode(&lastViewedAt)
-	if err != nil {
-		logger.Debug("Unable to load lastViewedAt: ", err)
-		return nil, err
-	}
-
-	if lastViewedAt["__LastViewedStateFormat__"] != lastViewedStateFormat {
-		logger.Debug("State format version mismatch: ", lastViewedAt["__LastViewedStateFormat__"], " vs. ", lastViewedStateFormat)
-		return nil, errors.New("version mismatch")
-	}
-	checksum := lastViewedAt["__LastViewedStateChecksum__"]
-	createtime := lastViewedAt["__LastViewedStateCreateTime__"]
-	savedtime := lastViewedAt["__LastViewedStateSavedTime__"]
-	if createtime^savedtime != checksum {
-		logger.Debug("Checksum mismatch: (saved checksum, state file creation, last saved time)", checksum, createtime, savedtime)
-		return nil, errors.New("checksum mismatch")
-	}
-
-	currentTime := model.GetMillis()
-
-	// Check if stale, time last saved older than defined
-	var stale int64
-	val, err := time.ParseDuration(staleDuration)
-	if err != nil {
-		stale = defaultStaleDuration
-	} else {
-		stale = val.Milliseconds()
-	}
-
-	lastSaved, ok := lastViewedAt["__LastViewedStateSavedTime__"]
-	if !ok || (lastSaved > 0 && lastSaved < currentTime-stale) {
-		logger.Debug("File stale? Last saved too old: ", time.Unix(lastViewedAt["__LastViewedStateSavedTime__"]/1000, 0))
-		return nil, errors.New("stale lastViewedAt state file")
-	}
-
-	return lastViewedAt, nil
-}
@@ -0,0 +1,230 @@
+package irckit
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/42wim/matterircd/bridge"
+	"github.com/sirupsen/logrus"
+	"github.com/sorcix/irc"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBridge implements the parts of bridge.Bridger used by the tests, other
+// methods panic.
+type fakeBridge struct {
+	bridge.Bridger
+
+	channels   map[string]*bridge.ChannelInfo
+	categories map[string]string
+	muted      map[string]bool
+}
+
+func (b *fakeBridge) Protocol() string {
+	return "mattermost"
+}
+
+func (b *fakeBridge) IsMuted(channelID string) bool {
+	return b.muted[channelID]
+}
+
+func (b *fakeBridge) GetCategory(channelID string) string {
+	return b.categories[channelID]
+}
+
+func (b *fakeBridge) GetChannel(channelID string) (*bridge.ChannelInfo, error) {
+	if info, ok := b.channels[channelID]; ok {
+		return info, nil
+	}
+
+	return nil, errors.New("channel not found")
+}
+
+func (b *fakeBridge) GetChannelName(channelID string) string {
+	if parentID, rootID, ok := bridge.ParseThreadChannelID(channelID); ok {
+		return bridge.ThreadChannelName(b.GetChannelName(parentID), rootID)
+	}
+
+	if info, ok := b.channels[channelID]; ok {
+		return "#" + info.Name
+	}
+
+	return ""
+}
+
+func (b *fakeBridge) Topic(channelID string) string {
+	if info, ok := b.channels[channelID]; ok {
+		return info.Topic
+	}
+
+	return ""
+}
+
+// nopConn is a Conn discarding the messages sent to it.
+type nopConn struct{}
+
+func (c nopConn) Close() error                  { return nil }
+func (c nopConn) Encode(msg *irc.Message) error { return nil }
+func (c nopConn) Decode() (*irc.Message, error) { return nil, errors.New("not implemented") }
+func (c nopConn) ResolveHost() string           { return "localhost" }
+
+// recordConn is a Conn remembering the messages sent to it.
+type recordConn struct {
+	nopConn
+
+	sync.Mutex
+	msgs []*irc.Message
+}
+
+func (c *recordConn) Encode(msg *irc.Message) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.msgs = append(c.msgs, msg)
+
+	return nil
+}
+
+// texts returns the texts of the messages sent.
+func (c *recordConn) texts() []string {
+	c.Lock()
+	defer c.Unlock()
+
+	var texts []string
+
+	for _, msg := range c.msgs {
+		texts = append(texts, msg.Trailing)
+	}
+
+	return texts
+}
+
+// newBridgeUser returns a user logged in to br, with its own server.
+func newBridgeUser(br bridge.Bridger) *User {
+	if logger == nil {
+		l := logrus.New()
+		l.SetOutput(ioutil.Discard)
+		SetLogger(logrus.NewEntry(l))
+	}
+
+	u := &User{
+		Conn:     nopConn{},
+		UserInfo: &bridge.UserInfo{Nick: "me", User: "me", Username: "me"},
+		channels: make(map[Channel]struct{}),
+		v:        viper.New(),
+	}
+	u.br = br
+
+	srv := NewServer("matterircd").(*server)
+	srv.u = u
+	u.Srv = srv
+
+	return u
+}
+
+func TestMutedRouting(t *testing.T) {
+	u := newBridgeUser(&fakeBridge{muted: map[string]bool{"muted": true}})
+
+	assert.Equal(t, "", u.mutedRouting("muted"), "MutedChannels not set")
+
+	u.v.Set("mattermost.MutedChannels", "messages")
+	assert.Equal(t, "messages", u.mutedRouting("muted"))
+	assert.Equal(t, "", u.mutedRouting("other"), "not muted")
+}
+
+func TestHideMuted(t *testing.T) {
+	br := &fakeBridge{
+		channels: map[string]*bridge.ChannelInfo{"muted": {ID: "muted", Name: "random"}},
+		muted:    map[string]bool{"muted": true},
+	}
+
+	u := newBridgeUser(br)
+	u.MentionKeys = []string{"deploy"}
+	u.v.Set("mattermost.MutedChannels", "hide")
+
+	assert.True(t, u.hideMuted("muted", "hello"))
+	assert.False(t, u.hideMuted("other", "hello"), "not muted")
+	assert.False(t, u.hideMuted("muted", "hello @me"), "mention")
+	assert.False(t, u.hideMuted("muted", "deploy done"), "mention key")
+
+	u.v.Set("mattermost.MutedChannels", "messages")
+	assert.False(t, u.hideMuted("muted", "hello"), "shown in &messages")
+	u.v.Set("mattermost.MutedChannels", "hide")
+
+	_, ok := u.Srv.HasChannel("muted")
+	assert.False(t, ok, "checking doesn't create the channel")
+
+	// a muted channel we joined explicitly is shown
+	u.Srv.Channel("muted").BatchJoin([]*User{u})
+	assert.False(t, u.hideMuted("muted", "hello"))
+}
+
+func TestMatchJoinRules(t *testing.T) {
+	u := newBridgeUser(&fakeBridge{categories: map[string]string{"dev": "Work", "town": "Favorites"}})
+
+	tests := []struct {
+		Desc      string
+		ChannelID string
+		Name      string
+		Rules     []string
+		Expected  bool
+	}{
+		{Desc: "no rules", ChannelID: "dev", Name: "#dev", Expected: false},
+		{Desc: "regexp", ChannelID: "dev", Name: "#dev", Rules: []string{"^#d"}, Expected: true},
+		{Desc: "regexp no match", ChannelID: "dev", Name: "#dev", Rules: []string{"^#ops$"}, Expected: false},
+		{Desc: "category", ChannelID: "dev", Name: "#dev", Rules: []string{"category:work"}, Expected: true},
+		{Desc: "other category", ChannelID: "town", Name: "#town-square", Rules: []string{"category:Work"}, Expected: false},
+		{Desc: "no category", ChannelID: "random", Name: "#random", Rules: []string{"category:Work"}, Expected: false},
+		{Desc: "category or regexp", ChannelID: "random", Name: "#random", Rules: []string{"category:Work", "random"}, Expected: true},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, u.matchJoinRules(tc.ChannelID, tc.Name, tc.Rules), tc.Desc)
+	}
+}
+
+func TestRenameChannel(t *testing.T) {
+	br := &fakeBridge{
+		channels: map[string]*bridge.ChannelInfo{"dev": {ID: "dev", Name: "dev", Topic: "releases"}},
+	}
+
+	u := newBridgeUser(br)
+	bob := &User{UserInfo: &bridge.UserInfo{Nick: "bob", User: "bob", Ghost: true}, channels: make(map[Channel]struct{})}
+
+	old := u.Srv.Channel("dev")
+	old.Join(u)
+	old.BatchJoin([]*User{bob})
+
+	oldThread := u.Srv.Channel(bridge.ThreadChannelID("dev", "root123456"))
+	oldThread.Join(u)
+	oldThread.BatchJoin([]*User{bob})
+
+	br.channels["dev"] = &bridge.ChannelInfo{ID: "dev", Name: "develop", Private: true, Topic: "releases"}
+
+	// no service user, the channel sets the mode
+	u.handleChannelUpdateEvent(&bridge.ChannelUpdateEvent{ChannelID: "dev"})
+
+	_, ok := u.Srv.HasChannel("#dev")
+	assert.False(t, ok, "old name unlinked")
+	assert.Empty(t, old.Users(), "ghosts parted from the old channel")
+	assert.NotContains(t, bob.channels, old)
+
+	ch, ok := u.Srv.HasChannel("#develop")
+	assert.True(t, ok)
+	assert.True(t, ch.HasUser(u))
+	assert.True(t, ch.HasUser(bob))
+	assert.True(t, ch.IsPrivate())
+	assert.Equal(t, "releases", ch.GetTopic())
+
+	thread, ok := u.Srv.HasChannel("#develop/t-root12")
+	assert.True(t, ok, "thread channel renamed")
+	assert.True(t, thread.HasUser(u))
+	assert.True(t, thread.HasUser(bob))
+	assert.Empty(t, oldThread.Users())
+
+	_, ok = u.Srv.HasChannel("#dev/t-root12")
+	assert.False(t, ok)
+}
@@ -0,0 +1,125 @@
+// Package emoji converts between emoji shortcodes (eg :thumbsup:) as used by
+// mattermost and slack, and unicode emoji.
+package emoji
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// variationSelector asks for the emoji presentation of the preceding character,
+// it's optional in what people type.
+const variationSelector = 0xfe0f
+
+var (
+	shortcodeRe = regexp.MustCompile(`:([a-z0-9_+\-]+):`)
+
+	// names maps emoji (see key) to their shortcode, stripped is the same
+	// without variation selectors.
+	names, stripped map[string]string
+	namesOnce       sync.Once
+)
+
+// Unicode returns the unicode emoji of shortcode name (without colons).
+func Unicode(name string) (string, bool) {
+	codes, ok := model.SystemEmojis[name]
+	if !ok {
+		return "", false
+	}
+
+	var sb strings.Builder
+
+	for _, code := range strings.Split(codes, "-") {
+		r, err := strconv.ParseInt(code, 16, 32)
+		if err != nil {
+			return "", false
+		}
+
+		sb.WriteRune(rune(r))
+	}
+
+	return sb.String(), true
+}
+
+// Name returns the shortcode (without colons) of the unicode emoji e.
+// Of emoji with multiple shortcodes (eg +1 and thumbsup) the shortest one is returned.
+func Name(e string) (string, bool) {
+	namesOnce.Do(loadNames)
+
+	if e == "" {
+		return "", false
+	}
+
+	if name, ok := names[key(e, false)]; ok {
+		return name, true
+	}
+
+	name, ok := stripped[key(e, true)]
+
+	return name, ok
+}
+
+// ToUnicode replaces the :shortcodes: of known emoji in text by unicode emoji.
+// Unknown shortcodes (eg custom emoji) are kept.
+func ToUnicode(text string) string {
+	if !strings.Contains(text, ":") {
+		return text
+	}
+
+	return shortcodeRe.ReplaceAllStringFunc(text, func(shortcode string) string {
+		if e, ok := Unicode(strings.Trim(shortcode, ":")); ok {
+			return e
+		}
+
+		return shortcode
+	})
+}
+
+// IsSystem returns true if name is a shortcode of a standard (non custom) emoji.
+func IsSystem(name string) bool {
+	_, ok := model.SystemEmojis[name]
+	return ok
+}
+
+func loadNames() {
+	names = make(map[string]string, len(model.SystemEmojis))
+	stripped = make(map[string]string, len(model.SystemEmojis))
+
+	add := func(m map[string]string, k, name string) {
+		if other, ok := m[k]; ok && (len(other) < len(name) || (len(other) == len(name) && other < name)) {
+			return
+		}
+
+		m[k] = name
+	}
+
+	for name := range model.SystemEmojis {
+		e, ok := Unicode(name)
+		if !ok {
+			continue
+		}
+
+		add(names, key(e, false), name)
+		add(stripped, key(e, true), name)
+	}
+}
+
+// key returns the lookup key of an emoji, optionally without variation selectors.
+func key(e string, stripVS bool) string {
+	var codes []string
+
+	for _, r := range e {
+		if stripVS && r == variationSelector {
+			continue
+		}
+
+		codes = append(codes, fmt.Sprintf("%x", r))
+	}
+
+	return strings.Join(codes, "-")
+}
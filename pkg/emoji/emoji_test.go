@@ -0,0 +1,48 @@
+package emoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToUnicode(t *testing.T) {
+	tests := []struct {
+		Desc     string
+		Text     string
+		Expected string
+	}{
+		{Desc: "no emoji", Text: "hello world", Expected: "hello world"},
+		{Desc: "shortcode", Text: "nice :thumbsup:", Expected: "nice 👍"},
+		{Desc: "alias", Text: ":+1::smile:", Expected: "👍😄"},
+		{Desc: "custom emoji", Text: "ship it :partyparrot:", Expected: "ship it :partyparrot:"},
+		{Desc: "zwj sequence", Text: ":man_technologist:", Expected: "👨‍💻"},
+		{Desc: "time", Text: "at 12:30", Expected: "at 12:30"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.Expected, ToUnicode(tc.Text), tc.Desc)
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		Desc     string
+		Emoji    string
+		Expected string
+		IsGood   bool
+	}{
+		{Desc: "shortest alias", Emoji: "👍", Expected: "+1", IsGood: true},
+		{Desc: "simple", Emoji: "😄", Expected: "smile", IsGood: true},
+		{Desc: "without variation selector", Emoji: "❤", Expected: "heart", IsGood: true},
+		{Desc: "with variation selector", Emoji: "❤️", Expected: "heart", IsGood: true},
+		{Desc: "text", Emoji: "abc"},
+		{Desc: "empty"},
+	}
+
+	for _, tc := range tests {
+		name, ok := Name(tc.Emoji)
+		assert.Equal(t, tc.IsGood, ok, tc.Desc)
+		assert.Equal(t, tc.Expected, name, tc.Desc)
+	}
+}
@@ -0,0 +1,46 @@
+package matterclient
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// apiRequest does a raw API request (url is relative to /api/v4), for the
+// endpoints our vendored mattermost client doesn't know about.
+// data is json encoded as body and the response is json decoded into result,
+// both are optional.
+func (m *Client) apiRequest(method, url string, data interface{}, result interface{}) error {
+	var body string
+
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		body = string(b)
+	}
+
+	for {
+		r, appErr := m.Client.DoApiRequest(method, m.Client.ApiUrl+url, body, "")
+		if appErr == nil {
+			defer func() {
+				io.Copy(ioutil.Discard, r.Body)
+				r.Body.Close()
+			}()
+
+			if result == nil {
+				return nil
+			}
+
+			return json.NewDecoder(r.Body).Decode(result)
+		}
+
+		if err := m.HandleRatelimit(method+" "+url, model.BuildErrorResponse(r, appErr)); err != nil {
+			return err
+		}
+	}
+}
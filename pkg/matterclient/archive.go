@@ -0,0 +1,127 @@
+package matterclient
+
+import (
+	"sort"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// getArchivedChannels returns the archived channels of teamID we may read.
+// They're nice to have, errors (eg viewing archived channels is disabled)
+// only get logged.
+func (m *Client) getArchivedChannels(teamID string) []*model.Channel {
+	var channels []*model.Channel
+
+	perPage := 200
+
+	for page := 0; ; page++ {
+		var (
+			mmchannels []*model.Channel
+			resp       *model.Response
+		)
+
+		for {
+			mmchannels, resp = m.Client.GetDeletedChannelsForTeam(teamID, page, perPage, "")
+			if resp.Error == nil {
+				break
+			}
+
+			if err := m.HandleRatelimit("GetDeletedChannelsForTeam", resp); err != nil {
+				m.logger.Debugf("no archived channels for team %s: %s", teamID, err)
+
+				return channels
+			}
+		}
+
+		channels = append(channels, mmchannels...)
+
+		if len(mmchannels) < perPage {
+			return channels
+		}
+	}
+}
+
+// archivedChannels returns the archived channels of t, sorted by name. The
+// caller holds the lock.
+func (t *Team) archivedChannels() []*model.Channel {
+	channels := make([]*model.Channel, 0, len(t.Archived))
+
+	for _, channel := range t.Archived {
+		channels = append(channels, channel)
+	}
+
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+
+	return channels
+}
+
+// IsArchived returns true if channelID is archived.
+func (m *Client) IsArchived(channelID string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, t := range m.OtherTeams {
+		if _, ok := t.Archived[channelID]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetChannelArchived marks the cached channelID as archived at deleteAt
+// (milliseconds), or as restored if deleteAt is 0. Channels we don't know are
+// ignored, UpdateChannels picks up the change in the channel lists.
+func (m *Client) SetChannelArchived(channelID string, deleteAt int64) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, t := range m.OtherTeams {
+		if deleteAt == 0 {
+			delete(t.Archived, channelID)
+			continue
+		}
+
+		for _, channel := range append(t.Channels, t.MoreChannels...) {
+			if channel.Id != channelID {
+				continue
+			}
+
+			archived := *channel
+			archived.DeleteAt = deleteAt
+
+			if t.Archived == nil {
+				t.Archived = make(map[string]*model.Channel)
+			}
+
+			t.Archived[channelID] = &archived
+
+			break
+		}
+	}
+}
+
+// ArchiveChannel archives or unarchives (restores) channelID.
+func (m *Client) ArchiveChannel(channelID string, archive bool) error {
+	var (
+		resp     *model.Response
+		deleteAt int64
+	)
+
+	if archive {
+		_, resp = m.Client.DeleteChannel(channelID)
+		deleteAt = model.GetMillis()
+	} else {
+		_, resp = m.Client.RestoreChannel(channelID)
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	m.SetChannelArchived(channelID, deleteAt)
+
+	return m.UpdateChannels()
+}
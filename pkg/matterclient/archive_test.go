@@ -0,0 +1,38 @@
+package matterclient
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetChannelArchived(t *testing.T) {
+	team := &Team{
+		ID:           "team",
+		Channels:     []*model.Channel{{Id: "dev", Name: "dev"}},
+		MoreChannels: []*model.Channel{{Id: "old", Name: "old", DeleteAt: 1}},
+		Archived:     map[string]*model.Channel{"old": {Id: "old", Name: "old", DeleteAt: 1}},
+	}
+
+	m := &Client{}
+	m.OtherTeams = []*Team{team}
+
+	assert.True(t, m.IsArchived("old"))
+	assert.False(t, m.IsArchived("dev"))
+
+	m.SetChannelArchived("dev", 42)
+	assert.True(t, m.IsArchived("dev"))
+	assert.Equal(t, int64(42), team.Archived["dev"].DeleteAt)
+	assert.Equal(t, int64(0), team.Channels[0].DeleteAt, "cached channel not modified")
+
+	m.SetChannelArchived("old", 0)
+	assert.False(t, m.IsArchived("old"))
+
+	m.SetChannelArchived("unknown", 42)
+	assert.False(t, m.IsArchived("unknown"))
+
+	archived := team.archivedChannels()
+	assert.Len(t, archived, 1)
+	assert.Equal(t, "dev", archived[0].Id)
+}
@@ -0,0 +1,105 @@
+package matterclient
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// FavoritesCategory is the name of the sidebar category of favorite channels.
+const FavoritesCategory = "Favorites"
+
+// SidebarCategory is a category of channels in the mattermost sidebar
+// (mattermost 5.26+).
+type SidebarCategory struct {
+	ID          string   `json:"id"`
+	TeamID      string   `json:"team_id"`
+	Type        string   `json:"type"`
+	DisplayName string   `json:"display_name"`
+	ChannelIDs  []string `json:"channel_ids"`
+}
+
+type sidebarCategories struct {
+	Categories []*SidebarCategory `json:"categories"`
+	Order      []string           `json:"order"`
+}
+
+// getCategories returns the sidebar categories of our channels in teamID, keyed
+// by channel ID. Servers without sidebar categories only have favorites.
+func (m *Client) getCategories(teamID string) (map[string]string, error) {
+	categories := make(map[string]string)
+	res := &sidebarCategories{}
+
+	err := m.apiRequest(http.MethodGet, m.Client.GetUserRoute(m.User.Id)+"/teams/"+teamID+"/channels/categories", nil, res)
+	if err == nil {
+		for _, category := range res.Categories {
+			name := category.DisplayName
+			if category.Type == "favorites" {
+				name = FavoritesCategory
+			}
+
+			for _, channelID := range category.ChannelIDs {
+				categories[channelID] = name
+			}
+		}
+
+		return categories, nil
+	}
+
+	m.logger.Debugf("no sidebar categories (%s), using favorites", err)
+
+	prefs, resp := m.Client.GetPreferencesByCategory(m.User.Id, model.PREFERENCE_CATEGORY_FAVORITE_CHANNEL)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	for _, pref := range prefs {
+		if pref.Value == "true" {
+			categories[pref.Name] = FavoritesCategory
+		}
+	}
+
+	return categories, nil
+}
+
+// UpdateCategories reloads the sidebar categories of all our teams.
+func (m *Client) UpdateCategories() {
+	var teamIDs []string
+
+	m.RLock()
+	for _, t := range m.OtherTeams {
+		teamIDs = append(teamIDs, t.ID)
+	}
+	m.RUnlock()
+
+	for _, teamID := range teamIDs {
+		categories, err := m.getCategories(teamID)
+		if err != nil {
+			m.logger.Errorf("updating categories of team %s failed: %s", teamID, err)
+			continue
+		}
+
+		m.Lock()
+		for _, t := range m.OtherTeams {
+			if t.ID == teamID {
+				t.Categories = categories
+			}
+		}
+		m.Unlock()
+	}
+}
+
+// GetCategory returns the sidebar category of channelID, "" if it hasn't one
+// (eg channels we're not a member of).
+func (m *Client) GetCategory(channelID string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, t := range m.OtherTeams {
+		if category, ok := t.Categories[channelID]; ok {
+			return category
+		}
+	}
+
+	return ""
+}
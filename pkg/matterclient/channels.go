@@ -39,6 +39,38 @@ func (m *Client) GetChannelHeader(channelID string) string {
 	return ""
 }
 
+// GetChannelPurpose returns the purpose of channelID.
+func (m *Client) GetChannelPurpose(channelID string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, t := range m.OtherTeams {
+		for _, channel := range append(t.Channels, t.MoreChannels...) {
+			if channel.Id == channelID {
+				return channel.Purpose
+			}
+		}
+	}
+
+	return ""
+}
+
+// SetChannel updates our cached copy of channel, eg after it's renamed.
+func (m *Client) SetChannel(channel *model.Channel) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, t := range m.OtherTeams {
+		for _, channels := range [][]*model.Channel{t.Channels, t.MoreChannels} {
+			for idx, c := range channels {
+				if c.Id == channel.Id {
+					channels[idx] = channel
+				}
+			}
+		}
+	}
+}
+
 func getNormalisedName(channel *model.Channel) string {
 	if channel.Type == model.CHANNEL_GROUP {
 		res := strings.ReplaceAll(channel.DisplayName, ", ", "-")
@@ -133,7 +165,8 @@ func (m *Client) GetLastViewedAt(channelID string) int64 {
 	}
 }
 
-// GetMoreChannels returns existing channels where we're not a member off.
+// GetMoreChannels returns existing channels where we're not a member off,
+// and archived channels.
 func (m *Client) GetMoreChannels() []*model.Channel {
 	m.RLock()
 	defer m.RUnlock()
@@ -180,11 +213,24 @@ func (m *Client) JoinChannel(channelID string) error {
 	m.RLock()
 	defer m.RUnlock()
 
-	for _, c := range m.Team.Channels {
-		if c.Id == channelID {
-			m.logger.Debug("Not joining ", channelID, " already joined.")
+	for _, t := range m.OtherTeams {
+		for _, c := range t.Channels {
+			if c.Id == channelID {
+				m.logger.Debug("Not joining ", channelID, " already joined.")
 
-			return nil
+				return nil
+			}
+		}
+	}
+
+	// archived channels can only be read
+	for _, t := range m.OtherTeams {
+		for _, c := range t.MoreChannels {
+			if c.Id == channelID && c.DeleteAt > 0 {
+				m.logger.Debug("Not joining ", channelID, " archived.")
+
+				return nil
+			}
 		}
 	}
 
@@ -237,7 +283,7 @@ func (m *Client) UpdateChannelsTeam(teamID string) error {
 	for idx, t := range m.OtherTeams {
 		if t.ID == teamID {
 			m.Lock()
-			m.OtherTeams[idx].MoreChannels = mmchannels
+			m.OtherTeams[idx].MoreChannels = append(mmchannels, t.archivedChannels()...)
 			m.Unlock()
 		}
 	}
@@ -245,13 +291,18 @@ func (m *Client) UpdateChannelsTeam(teamID string) error {
 	return nil
 }
 
+// UpdateChannels updates the channels of all our teams.
 func (m *Client) UpdateChannels() error {
-	if err := m.UpdateChannelsTeam(m.Team.ID); err != nil {
-		return err
-	}
+	var teamIDs []string
 
+	m.RLock()
 	for _, t := range m.OtherTeams {
-		if err := m.UpdateChannelsTeam(t.ID); err != nil {
+		teamIDs = append(teamIDs, t.ID)
+	}
+	m.RUnlock()
+
+	for _, teamID := range teamIDs {
+		if err := m.UpdateChannelsTeam(teamID); err != nil {
 			return err
 		}
 	}
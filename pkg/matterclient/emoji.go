@@ -0,0 +1,29 @@
+package matterclient
+
+// GetCustomEmojiNames returns the names of the custom emoji of the server.
+func (m *Client) GetCustomEmojiNames() ([]string, error) {
+	var names []string
+
+	perPage := 200
+
+	for page := 0; ; page++ {
+		emojis, resp := m.Client.GetEmojiList(page, perPage)
+		if resp.Error != nil {
+			if err := m.HandleRatelimit("GetEmojiList", resp); err != nil {
+				return nil, err
+			}
+
+			page--
+
+			continue
+		}
+
+		for _, emoji := range emojis {
+			names = append(names, emoji.Name)
+		}
+
+		if len(emojis) < perPage {
+			return names, nil
+		}
+	}
+}
@@ -38,8 +38,11 @@ type Team struct {
 	Team         *model.Team
 	ID           string
 	Channels     []*model.Channel
-	MoreChannels []*model.Channel
+	MoreChannels []*model.Channel          // public channels we're not a member of and archived channels
+	Archived     map[string]*model.Channel // archived channels we may read, by ID
 	Users        map[string]*model.User
+	NotifyProps  map[string]model.StringMap // our notification preferences per channel
+	Categories   map[string]string          // sidebar category per channel
 }
 
 type Message struct {
@@ -70,12 +73,29 @@ type Client struct {
 	OnWsConnect   func()
 	reconnectBusy bool
 
+	// ResolveSecret resolves secret references (eg env:, file:, cmd:) in the
+	// credentials. It's called on every (re)connect.
+	ResolveSecret func(string) (string, error)
+	secretRefs    *Credentials
+
+	// SessionDir is used to persist (encrypted) session tokens, so that a
+	// restart doesn't need a new password/MFA login.
+	SessionDir string
+	// OnMFARequired is called when a reconnect needs a new MFA token,
+	// which can be given with SetMFAToken.
+	OnMFARequired func()
+	sessionToken  string
+	mfaChan       chan string
+
 	logger      *logrus.Entry
 	rootLogger  *logrus.Logger
 	lruCache    *lru.Cache
 	aliveChan   chan bool
 	loginCancel context.CancelFunc
 	lastPong    time.Time
+
+	statsMutex   sync.Mutex
+	memberCounts map[string]memberCount
 }
 
 func New(login string, pass string, team string, server string, mfatoken string) *Client {
@@ -104,6 +124,7 @@ func New(login string, pass string, team string, server string, mfatoken string)
 		lruCache:    cache,
 		logger:      rootLogger.WithFields(logrus.Fields{"prefix": "matterclient"}),
 		aliveChan:   make(chan bool),
+		mfaChan:     make(chan string, 1),
 	}
 }
 
@@ -126,6 +147,10 @@ func (m *Client) Login() error {
 		Jitter: true,
 	}
 
+	if err := m.resolveCredentials(); err != nil {
+		return err
+	}
+
 	// do initialization setup
 	if err := m.initClient(b); err != nil {
 		return err
@@ -190,6 +215,13 @@ func (m *Client) Reconnect() {
 	for {
 		m.logger.Info("reconnect: login")
 		err := m.Login()
+		if errors.Is(err, ErrMFARequired) {
+			m.logger.Errorf("reconnect: login failed: %s", err)
+			m.waitForMFAToken()
+
+			continue
+		}
+
 		if err != nil {
 			m.logger.Errorf("reconnect: login failed: %s, retrying in 10 seconds", err)
 			time.Sleep(time.Second * 10)
@@ -252,6 +284,47 @@ func (m *Client) handleLoginToken() error {
 		m.Credentials.Token = token[1]
 	}
 
+	if m.ResolveSecret != nil && m.Credentials.Token != "" {
+		token, err := m.ResolveSecret(m.Credentials.Token)
+		if err != nil {
+			return err
+		}
+
+		m.Credentials.Token = token
+	}
+
+	return nil
+}
+
+// resolveCredentials replaces secret references in the credentials with their
+// actual values. The references are kept so we can resolve them again on a
+// reconnect (eg when the secret has been rotated).
+func (m *Client) resolveCredentials() error {
+	if m.ResolveSecret == nil {
+		return nil
+	}
+
+	if m.secretRefs == nil {
+		refs := *m.Credentials
+		m.secretRefs = &refs
+	}
+
+	var err error
+
+	m.Credentials.Token = m.secretRefs.Token
+
+	if m.Credentials.Login, err = m.ResolveSecret(m.secretRefs.Login); err != nil {
+		return err
+	}
+
+	if m.Credentials.Pass, err = m.ResolveSecret(m.secretRefs.Pass); err != nil {
+		return err
+	}
+
+	if m.Credentials.MFAToken, err = m.ResolveSecret(m.secretRefs.MFAToken); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -278,72 +351,169 @@ func (m *Client) serverAlive(b *backoff.Backoff) error {
 }
 
 // initialize user and teams
-// nolint:funlen
 func (m *Client) initUser() error {
 	m.Lock()
 	defer m.Unlock()
-	// we only load all team data on initial login.
-	// all other updates are for channels from our (primary) team only.
+	// we load all team data on login, teams joined or left later are
+	// handled by AddTeam and RemoveTeam.
 	teams, resp := m.Client.GetTeamsForUser(m.User.Id, "")
 	if resp.Error != nil {
 		return resp.Error
 	}
 
 	for _, team := range teams {
-		idx := 0
-		max := 200
-		usermap := make(map[string]*model.User)
-
-		mmusers, resp := m.Client.GetUsersInTeam(team.Id, idx, max, "")
-		if resp.Error != nil {
-			return errors.New(resp.Error.DetailedError)
+		t, err := m.loadTeam(team)
+		if err != nil {
+			return err
 		}
 
-		for len(mmusers) > 0 {
-			for _, user := range mmusers {
-				usermap[user.Id] = user
-			}
+		m.OtherTeams = append(m.OtherTeams, t)
 
-			mmusers, resp = m.Client.GetUsersInTeam(team.Id, idx, max, "")
-			if resp.Error != nil {
-				return errors.New(resp.Error.DetailedError)
-			}
+		if team.Name == m.Credentials.Team {
+			m.Team = t
+			m.logger.Debugf("initUser(): found our team %s (id: %s)", team.Name, team.Id)
+		}
+		// add all users
+		for k, v := range t.Users {
+			m.Users[k] = v
+		}
+	}
 
-			idx++
+	return nil
+}
 
-			time.Sleep(time.Millisecond * 200)
-		}
+// loadTeam loads the users and channels of team.
+func (m *Client) loadTeam(team *model.Team) (*Team, error) {
+	idx := 0
+	max := 200
+	usermap := make(map[string]*model.User)
 
-		m.logger.Infof("found %d users in team %s", len(usermap), team.Name)
+	mmusers, resp := m.Client.GetUsersInTeam(team.Id, idx, max, "")
+	if resp.Error != nil {
+		return nil, errors.New(resp.Error.DetailedError)
+	}
 
-		t := &Team{
-			Team:  team,
-			Users: usermap,
-			ID:    team.Id,
+	for len(mmusers) > 0 {
+		for _, user := range mmusers {
+			usermap[user.Id] = user
 		}
 
-		mmchannels, resp := m.Client.GetChannelsForTeamForUser(team.Id, m.User.Id, false, "")
+		mmusers, resp = m.Client.GetUsersInTeam(team.Id, idx, max, "")
 		if resp.Error != nil {
-			return resp.Error
+			return nil, errors.New(resp.Error.DetailedError)
 		}
 
-		t.Channels = mmchannels
+		idx++
 
-		mmchannels, resp = m.Client.GetPublicChannelsForTeam(team.Id, 0, 5000, "")
-		if resp.Error != nil {
-			return resp.Error
-		}
+		time.Sleep(time.Millisecond * 200)
+	}
 
-		t.MoreChannels = mmchannels
-		m.OtherTeams = append(m.OtherTeams, t)
+	m.logger.Infof("found %d users in team %s", len(usermap), team.Name)
 
-		if team.Name == m.Credentials.Team {
-			m.Team = t
-			m.logger.Debugf("initUser(): found our team %s (id: %s)", team.Name, team.Id)
+	t := &Team{
+		Team:  team,
+		Users: usermap,
+		ID:    team.Id,
+	}
+
+	mmchannels, resp := m.Client.GetChannelsForTeamForUser(team.Id, m.User.Id, false, "")
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	t.Channels = mmchannels
+
+	mmchannels, resp = m.Client.GetPublicChannelsForTeam(team.Id, 0, 5000, "")
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	// archived channels are only fetched here, channel_deleted and
+	// channel_restored events keep them up to date
+	t.Archived = make(map[string]*model.Channel)
+
+	for _, channel := range m.getArchivedChannels(team.Id) {
+		t.Archived[channel.Id] = channel
+	}
+
+	t.MoreChannels = append(mmchannels, t.archivedChannels()...)
+
+	members, resp := m.Client.GetChannelMembersForUser(m.User.Id, team.Id, "")
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	t.NotifyProps = make(map[string]model.StringMap)
+
+	for _, member := range *members {
+		t.NotifyProps[member.ChannelId] = member.NotifyProps
+	}
+
+	// categories are nice to have, don't fail the login for them
+	categories, err := m.getCategories(team.Id)
+	if err != nil {
+		m.logger.Errorf("getting categories of team %s failed: %s", team.Name, err)
+	}
+
+	t.Categories = categories
+
+	return t, nil
+}
+
+// AddTeam loads a team we've joined after login.
+func (m *Client) AddTeam(teamID string) error {
+	if m.GetTeamName(teamID) != "" {
+		return nil
+	}
+
+	team, resp := m.Client.GetTeam(teamID, "")
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	t, err := m.loadTeam(team)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	// added meanwhile by a concurrent AddTeam
+	for _, other := range m.OtherTeams {
+		if other.ID == teamID {
+			return nil
 		}
-		// add all users
-		for k, v := range t.Users {
-			m.Users[k] = v
+	}
+
+	m.OtherTeams = append(m.OtherTeams, t)
+
+	for k, v := range t.Users {
+		m.Users[k] = v
+	}
+
+	m.logger.Infof("added team %s (id: %s)", team.Name, team.Id)
+
+	return nil
+}
+
+// RemoveTeam removes a team we've left and returns the channels we were a member of.
+// Our primary team can't be removed.
+func (m *Client) RemoveTeam(teamID string) []*model.Channel {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.Team != nil && m.Team.ID == teamID {
+		m.logger.Warnf("left our primary team %s", teamID)
+		return nil
+	}
+
+	for idx, t := range m.OtherTeams {
+		if t.ID == teamID {
+			m.OtherTeams = append(m.OtherTeams[:idx], m.OtherTeams[idx+1:]...)
+			m.logger.Infof("removed team %s (id: %s)", t.Team.Name, teamID)
+
+			return t.Channels
 		}
 	}
 
@@ -359,6 +529,15 @@ func (m *Client) doLogin(firstConnection bool, b *backoff.Backoff) error {
 		user   *model.User
 	)
 
+	// reuse the session of a previous password login
+	if m.Credentials.Token == "" {
+		if user, ok := m.loginWithSession(); ok {
+			m.User = user
+
+			return nil
+		}
+	}
+
 	for {
 		m.logger.Debugf("%s %s %s %s", logmsg, m.Credentials.Team, m.Credentials.Login, m.Credentials.Server)
 
@@ -379,6 +558,11 @@ func (m *Client) doLogin(firstConnection bool, b *backoff.Backoff) error {
 			d := b.Duration()
 			m.logger.Debug(appErr.DetailedError)
 
+			// retrying won't help, we need a new MFA token
+			if m.Credentials.Token == "" && isMFAError(appErr) {
+				return fmt.Errorf("%w: %s", ErrMFARequired, appErr.Message)
+			}
+
 			if firstConnection {
 				if appErr.Message == "" {
 					return errors.New(appErr.DetailedError)
@@ -398,6 +582,10 @@ func (m *Client) doLogin(firstConnection bool, b *backoff.Backoff) error {
 
 		m.User = user
 
+		if m.Credentials.Token == "" {
+			m.keepSessionToken()
+		}
+
 		break
 	}
 	// reset timer
@@ -640,7 +828,8 @@ func (m *Client) WsReceiver(ctx context.Context) {
 
 // Logout disconnects the client from the chat server.
 func (m *Client) reconnectLogout() error {
-	err := m.Logout()
+	// keep our session, we want to reuse it on reconnect
+	err := m.logout(false)
 	m.WsQuit = false
 
 	if err != nil {
@@ -652,6 +841,11 @@ func (m *Client) reconnectLogout() error {
 
 // Logout disconnects the client from the chat server.
 func (m *Client) Logout() error {
+	// a persisted session must survive a logout
+	return m.logout(m.SessionDir == "")
+}
+
+func (m *Client) logout(invalidate bool) error {
 	m.logger.Debug("logout running loginCancel to exit goroutines")
 	m.loginCancel()
 
@@ -667,7 +861,14 @@ func (m *Client) Logout() error {
 		return nil
 	}
 
+	if !invalidate {
+		m.logger.Debug("Not invalidating session in logout, session will be reused")
+
+		return nil
+	}
+
 	// actually log out
+	m.sessionToken = ""
 	m.logger.Debug("running m.Client.Logout")
 	_, resp := m.Client.Logout()
 	if resp.Error != nil {
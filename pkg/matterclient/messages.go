@@ -2,6 +2,7 @@ package matterclient
 
 import (
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 )
@@ -65,6 +66,20 @@ func (m *Client) GetPosts(channelID string, limit int) *model.PostList {
 	}
 }
 
+// GetPostThread returns all posts of the thread postID is part of.
+func (m *Client) GetPostThread(postID string) *model.PostList {
+	for {
+		res, resp := m.Client.GetPostThread(postID, "")
+		if resp.Error == nil {
+			return res
+		}
+
+		if err := m.HandleRatelimit("GetPostThread", resp); err != nil {
+			return nil
+		}
+	}
+}
+
 func (m *Client) GetPostsSince(channelID string, time int64) *model.PostList {
 	for {
 		res, resp := m.Client.GetPostsSince(channelID, time)
@@ -141,13 +156,66 @@ func (m *Client) PostMessageWithFiles(channelID string, text string, rootID stri
 	}
 }
 
-func (m *Client) SearchPosts(query string) *model.PostList {
-	res, resp := m.Client.SearchPosts(m.Team.ID, query, false)
-	if resp.Error != nil {
-		return nil
+// searchLimit is the maximum number of search results per team.
+const searchLimit = 100
+
+// SearchPosts searches the posts of teamID, or all our teams when it's empty,
+// newest first. Archived channels are searched too.
+func (m *Client) SearchPosts(query, teamID string) *model.PostList {
+	teamIDs := []string{teamID}
+
+	if teamID == "" {
+		teamIDs = nil
+
+		m.RLock()
+		for _, t := range m.OtherTeams {
+			teamIDs = append(teamIDs, t.ID)
+		}
+		m.RUnlock()
 	}
 
-	return res
+	// dates in before: and after: are in our timezone
+	_, offset := time.Now().Zone()
+	page, perPage, isOrSearch, includeDeleted := 0, searchLimit, false, true
+
+	params := &model.SearchParameter{
+		Terms:                  &query,
+		IsOrSearch:             &isOrSearch,
+		TimeZoneOffset:         &offset,
+		Page:                   &page,
+		PerPage:                &perPage,
+		IncludeDeletedChannels: &includeDeleted,
+	}
+
+	var postlist *model.PostList
+
+	for _, id := range teamIDs {
+		res, resp := m.Client.SearchPostsWithParams(id, params)
+		if resp.Error != nil {
+			m.logger.Errorf("search in team %s failed: %s", id, resp.Error)
+			continue
+		}
+
+		if postlist == nil {
+			postlist = model.NewPostList()
+		}
+
+		// direct messages are found in every team
+		for _, id := range res.Order {
+			if _, ok := postlist.Posts[id]; ok {
+				continue
+			}
+
+			postlist.AddPost(res.Posts[id])
+			postlist.AddOrder(id)
+		}
+	}
+
+	if postlist != nil {
+		postlist.SortByCreateAt()
+	}
+
+	return postlist
 }
 
 // SendDirectMessage sends a direct message to specified user
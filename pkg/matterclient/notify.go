@@ -0,0 +1,81 @@
+package matterclient
+
+import (
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// GetNotifyProps returns our notification preferences (mark_unread, desktop,
+// push, ...) of channelID, nil if they're unknown.
+func (m *Client) GetNotifyProps(channelID string) model.StringMap {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, t := range m.OtherTeams {
+		if props, ok := t.NotifyProps[channelID]; ok {
+			return props
+		}
+	}
+
+	return nil
+}
+
+// SetNotifyProps updates our cached notification preferences of channelID,
+// eg after a channel_member_updated event.
+func (m *Client) SetNotifyProps(channelID string, props model.StringMap) {
+	teamID := m.GetTeamFromChannel(channelID)
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, t := range m.OtherTeams {
+		// group and direct messages are in every team
+		if t.ID == teamID || teamID == "G" || teamID == "" {
+			if t.NotifyProps == nil {
+				t.NotifyProps = make(map[string]model.StringMap)
+			}
+
+			t.NotifyProps[channelID] = props
+		}
+	}
+}
+
+// IsMuted returns true if channelID is muted: only mentions mark it unread.
+// This is what "Mute Channel" in mattermost sets, the desktop and push
+// notification props only change notifications and aren't used.
+func (m *Client) IsMuted(channelID string) bool {
+	return m.GetNotifyProps(channelID)[model.MARK_UNREAD_NOTIFY_PROP] == model.CHANNEL_MARK_UNREAD_MENTION
+}
+
+// MuteChannel mutes or unmutes channelID.
+func (m *Client) MuteChannel(channelID string, mute bool) error {
+	props := model.StringMap{model.MARK_UNREAD_NOTIFY_PROP: model.CHANNEL_MARK_UNREAD_ALL}
+	if mute {
+		props[model.MARK_UNREAD_NOTIFY_PROP] = model.CHANNEL_MARK_UNREAD_MENTION
+	}
+
+	for {
+		_, resp := m.Client.UpdateChannelNotifyProps(channelID, m.User.Id, props)
+		if resp.Error == nil {
+			break
+		}
+
+		if err := m.HandleRatelimit("UpdateChannelNotifyProps", resp); err != nil {
+			return err
+		}
+	}
+
+	// the server only returns ok, merge our change into the cached props
+	updated := model.StringMap{}
+
+	for k, v := range m.GetNotifyProps(channelID) {
+		updated[k] = v
+	}
+
+	for k, v := range props {
+		updated[k] = v
+	}
+
+	m.SetNotifyProps(channelID, updated)
+
+	return nil
+}
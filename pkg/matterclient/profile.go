@@ -0,0 +1,60 @@
+package matterclient
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// profileTTL is how long fetched profiles are cached.
+const profileTTL = 5 * time.Minute
+
+// Profile is a freshly fetched user with details we don't keep up to date in
+// Users, eg for WHOIS.
+type Profile struct {
+	User           *model.User
+	LastActivityAt int64    // in milliseconds, 0 if unknown
+	Teams          []string // names of our teams the user is a member of
+
+	fetched time.Time
+}
+
+// GetProfile returns the profile of userID, it's fetched on demand and cached.
+func (m *Client) GetProfile(userID string) (*Profile, error) {
+	key := "profile:" + userID
+
+	if cached, ok := m.lruCache.Get(key); ok {
+		if p := cached.(*Profile); time.Since(p.fetched) < profileTTL {
+			return p, nil
+		}
+	}
+
+	user, resp := m.Client.GetUser(userID, "")
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	p := &Profile{
+		User:    user,
+		fetched: time.Now(),
+	}
+
+	// the last activity is nice to have
+	if status, resp := m.Client.GetUserStatus(userID, ""); resp.Error == nil {
+		p.LastActivityAt = status.LastActivityAt
+	}
+
+	m.RLock()
+
+	for _, t := range m.OtherTeams {
+		if _, ok := t.Users[userID]; ok {
+			p.Teams = append(p.Teams, t.Team.Name)
+		}
+	}
+
+	m.RUnlock()
+
+	m.lruCache.Add(key, p)
+
+	return p, nil
+}
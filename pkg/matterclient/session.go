@@ -0,0 +1,224 @@
+package matterclient
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrMFARequired is returned when the server needs a (new) MFA token to login.
+var ErrMFARequired = errors.New("MFA token required")
+
+// isMFAError returns true if the login failed because of a missing or invalid MFA token.
+func isMFAError(appErr *model.AppError) bool {
+	switch appErr.Id {
+	case "api.user.check_user_mfa.bad_code.app_error", "mfa.validate_token.authenticate.app_error":
+		return true
+	}
+
+	return false
+}
+
+// SetMFAToken sets a new MFA token, this wakes up a reconnect waiting for one.
+func (m *Client) SetMFAToken(token string) {
+	m.Lock()
+	m.Credentials.MFAToken = token
+	if m.secretRefs != nil {
+		m.secretRefs.MFAToken = token
+	}
+	m.Unlock()
+
+	select {
+	case m.mfaChan <- token:
+	default:
+	}
+}
+
+// waitForMFAToken blocks until a new MFA token is set with SetMFAToken or
+// until we're logged out.
+func (m *Client) waitForMFAToken() {
+	m.Lock()
+	m.Credentials.MFAToken = ""
+	if m.secretRefs != nil {
+		m.secretRefs.MFAToken = ""
+	}
+	m.Unlock()
+
+	if m.OnMFARequired != nil {
+		go m.OnMFARequired()
+	}
+
+	m.logger.Info("reconnect: waiting for a new MFA token")
+
+	for !m.WsQuit {
+		select {
+		case <-m.mfaChan:
+			return
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+// loginWithSession tries to login with the session token of a previous
+// password login, so we don't need the (expired) MFA token again.
+func (m *Client) loginWithSession() (*model.User, bool) {
+	if m.sessionToken == "" {
+		m.sessionToken = m.loadSessionToken()
+	}
+
+	if m.sessionToken == "" {
+		return nil, false
+	}
+
+	m.logger.Debug("trying login with session token")
+
+	m.Client.AuthType = model.HEADER_BEARER
+	m.Client.AuthToken = m.sessionToken
+
+	user, resp := m.Client.GetMe("")
+	if resp.Error != nil || user == nil {
+		if resp.StatusCode == http.StatusUnauthorized {
+			m.logger.Info("session token expired")
+			m.forgetSessionToken()
+		}
+
+		m.Client.AuthToken = ""
+
+		return nil, false
+	}
+
+	return user, true
+}
+
+// keepSessionToken remembers the session token of the current login.
+func (m *Client) keepSessionToken() {
+	m.sessionToken = m.Client.AuthToken
+	m.saveSessionToken()
+}
+
+func (m *Client) forgetSessionToken() {
+	m.sessionToken = ""
+
+	if path := m.sessionFile(); path != "" {
+		os.Remove(path)
+	}
+}
+
+// sessionFile returns the file used to persist the session token in SessionDir.
+// The name only depends on the server and login, the content is encrypted with
+// a key derived from the password (see sessionCipher).
+func (m *Client) sessionFile() string {
+	if m.SessionDir == "" || m.Credentials.Login == "" || m.Credentials.Pass == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(m.Credentials.Server + "\x00" + m.Credentials.Login))
+
+	return filepath.Join(m.SessionDir, hex.EncodeToString(sum[:16])+".session")
+}
+
+// sessionSaltSize is the size of the random salt stored in front of the
+// encrypted session token.
+const sessionSaltSize = 16
+
+// sessionCipher returns the cipher for the session file, with a key derived
+// from the server, login and password using scrypt and salt.
+func (m *Client) sessionCipher(salt []byte) (cipher.AEAD, error) {
+	secret := []byte(m.Credentials.Server + "\x00" + m.Credentials.Login + "\x00" + m.Credentials.Pass)
+
+	key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (m *Client) saveSessionToken() {
+	path := m.sessionFile()
+	if path == "" || m.sessionToken == "" {
+		return
+	}
+
+	salt := make([]byte, sessionSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		m.logger.Errorf("session token not saved: %s", err)
+		return
+	}
+
+	gcm, err := m.sessionCipher(salt)
+	if err != nil {
+		m.logger.Errorf("session token not saved: %s", err)
+		return
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		m.logger.Errorf("session token not saved: %s", err)
+		return
+	}
+
+	// the file contains the salt, the nonce and the encrypted token.
+	data := gcm.Seal(append(salt, nonce...), nonce, []byte(m.sessionToken), nil)
+
+	if err = os.MkdirAll(m.SessionDir, 0o700); err != nil {
+		m.logger.Errorf("session token not saved: %s", err)
+		return
+	}
+
+	if err = ioutil.WriteFile(path, data, 0o600); err != nil {
+		m.logger.Errorf("session token not saved: %s", err)
+	}
+}
+
+func (m *Client) loadSessionToken() string {
+	path := m.sessionFile()
+	if path == "" {
+		return ""
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	if len(data) < sessionSaltSize {
+		return ""
+	}
+
+	gcm, err := m.sessionCipher(data[:sessionSaltSize])
+	if err != nil {
+		return ""
+	}
+
+	data = data[sessionSaltSize:]
+	if len(data) < gcm.NonceSize() {
+		return ""
+	}
+
+	// this fails when the password has changed, in that case we need a new login anyway.
+	token, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		m.logger.Debugf("can't decrypt session token: %s", err)
+		return ""
+	}
+
+	return string(token)
+}
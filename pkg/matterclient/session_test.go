@@ -0,0 +1,39 @@
+package matterclient
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "matterclient")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	m := New("me", "secret", "team", "chat.example.com", "")
+	m.SessionDir = dir
+	m.sessionToken = "token1234"
+	m.saveSessionToken()
+
+	first, err := ioutil.ReadFile(m.sessionFile())
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(first, []byte("token1234")))
+	assert.Equal(t, "token1234", m.loadSessionToken())
+
+	// every save uses a new salt
+	m.saveSessionToken()
+
+	second, err := ioutil.ReadFile(m.sessionFile())
+	assert.NoError(t, err)
+	assert.NotEqual(t, first[:sessionSaltSize], second[:sessionSaltSize])
+	assert.Equal(t, "token1234", m.loadSessionToken())
+
+	// a changed password can't decrypt the token
+	m.Credentials.Pass = "changed"
+	assert.Equal(t, "", m.loadSessionToken())
+}
@@ -0,0 +1,60 @@
+package matterclient
+
+import (
+	"time"
+)
+
+// memberCountTTL is how long member counts are cached, listing all channels of
+// a large server shouldn't ask for every count each time.
+const memberCountTTL = 10 * time.Minute
+
+type memberCount struct {
+	count   int64
+	fetched time.Time
+}
+
+// CachedMemberCount returns the number of members of channelID if it's cached,
+// without asking the server.
+func (m *Client) CachedMemberCount(channelID string) (int64, bool) {
+	m.statsMutex.Lock()
+	cached, ok := m.memberCounts[channelID]
+	m.statsMutex.Unlock()
+
+	if !ok || time.Since(cached.fetched) >= memberCountTTL {
+		return 0, false
+	}
+
+	return cached.count, true
+}
+
+// GetMemberCount returns the number of members of channelID.
+func (m *Client) GetMemberCount(channelID string) (int64, error) {
+	if count, ok := m.CachedMemberCount(channelID); ok {
+		return count, nil
+	}
+
+	for {
+		stats, resp := m.Client.GetChannelStats(channelID, "")
+		if resp.Error == nil {
+			m.setMemberCount(channelID, stats.MemberCount)
+
+			return stats.MemberCount, nil
+		}
+
+		if err := m.HandleRatelimit("GetChannelStats", resp); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// setMemberCount updates the cached member count of channelID.
+func (m *Client) setMemberCount(channelID string, count int64) {
+	m.statsMutex.Lock()
+	defer m.statsMutex.Unlock()
+
+	if m.memberCounts == nil {
+		m.memberCounts = make(map[string]memberCount)
+	}
+
+	m.memberCounts[channelID] = memberCount{count: count, fetched: time.Now()}
+}
@@ -0,0 +1,26 @@
+package matterclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedMemberCount(t *testing.T) {
+	m := &Client{}
+
+	_, ok := m.CachedMemberCount("chan")
+	assert.False(t, ok)
+
+	m.setMemberCount("chan", 42)
+
+	count, ok := m.CachedMemberCount("chan")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), count)
+
+	m.memberCounts["chan"] = memberCount{count: 42, fetched: time.Now().Add(-memberCountTTL)}
+
+	_, ok = m.CachedMemberCount("chan")
+	assert.False(t, ok, "expired")
+}
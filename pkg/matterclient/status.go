@@ -0,0 +1,90 @@
+package matterclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// CustomStatus is the custom status of a user (mattermost 5.36+).
+type CustomStatus struct {
+	Emoji     string    `json:"emoji"`
+	Text      string    `json:"text"`
+	Duration  string    `json:"duration,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired returns true if the custom status has an expiry time in the past.
+func (cs *CustomStatus) Expired() bool {
+	return !cs.ExpiresAt.IsZero() && cs.ExpiresAt.Before(time.Now())
+}
+
+// SetCustomStatus sets our custom status, which expires after expires when
+// it isn't zero.
+func (m *Client) SetCustomStatus(emoji, text string, expires time.Duration) error {
+	cs := &CustomStatus{
+		Emoji: emoji,
+		Text:  text,
+	}
+
+	if expires > 0 {
+		cs.Duration = "date_and_time"
+		cs.ExpiresAt = time.Now().Add(expires).UTC()
+	}
+
+	return m.apiRequest(http.MethodPut, m.Client.GetUserRoute("me")+"/status/custom", cs, nil)
+}
+
+// RemoveCustomStatus removes our custom status.
+func (m *Client) RemoveCustomStatus() error {
+	return m.apiRequest(http.MethodDelete, m.Client.GetUserRoute("me")+"/status/custom", nil, nil)
+}
+
+// GetCustomStatus returns the custom status of userID, or nil if there's no
+// (unexpired) custom status.
+func (m *Client) GetCustomStatus(userID string) *CustomStatus {
+	user := m.GetUser(userID)
+	if user == nil || user.Props["customStatus"] == "" {
+		return nil
+	}
+
+	cs := &CustomStatus{}
+
+	if err := json.Unmarshal([]byte(user.Props["customStatus"]), cs); err != nil {
+		m.logger.Debugf("GetCustomStatus(): decoding custom status of %s failed: %s", userID, err)
+		return nil
+	}
+
+	if (cs.Emoji == "" && cs.Text == "") || cs.Expired() {
+		return nil
+	}
+
+	return cs
+}
+
+// SetStatus sets our status (online, away, dnd or offline). A dnd status
+// ends after until (mattermost 5.37+), when it isn't zero.
+func (m *Client) SetStatus(status string, until time.Duration) error {
+	data := map[string]interface{}{
+		"user_id": m.User.Id,
+		"status":  status,
+	}
+
+	if status == model.STATUS_DND && until > 0 {
+		data["dnd_end_time"] = time.Now().Add(until).Unix()
+	}
+
+	return m.apiRequest(http.MethodPut, m.Client.GetUserRoute(m.User.Id)+"/status", data, nil)
+}
+
+// CacheUser updates our cached user (eg after a user_updated event).
+func (m *Client) CacheUser(user *model.User) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.Users[user.Id]; ok {
+		m.Users[user.Id] = user
+	}
+}
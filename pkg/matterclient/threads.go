@@ -0,0 +1,92 @@
+package matterclient
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// Thread is a (collapsed reply) thread we follow.
+type Thread struct {
+	ID             string      `json:"id"`
+	ReplyCount     int64       `json:"reply_count"`
+	LastReplyAt    int64       `json:"last_reply_at"`
+	LastViewedAt   int64       `json:"last_viewed_at"`
+	UnreadReplies  int64       `json:"unread_replies"`
+	UnreadMentions int64       `json:"unread_mentions"`
+	Post           *model.Post `json:"post"`
+}
+
+type threadList struct {
+	Threads []*Thread `json:"threads"`
+}
+
+// threadRoute returns the route of a (collapsed reply) thread of our user.
+// Group and direct messages don't have a team, any of our teams will do.
+func (m *Client) threadRoute(teamID, threadID string) string {
+	if teamID == "" || teamID == "G" {
+		teamID = m.Team.ID
+	}
+
+	return m.Client.GetUserRoute("me") + "/teams/" + teamID + "/threads/" + threadID
+}
+
+// FollowThread follows or unfollows the thread with root post threadID
+// (mattermost 5.29+ with collapsed reply threads).
+func (m *Client) FollowThread(teamID, threadID string, follow bool) error {
+	method := http.MethodPut
+	if !follow {
+		method = http.MethodDelete
+	}
+
+	return m.apiRequest(method, m.threadRoute(teamID, threadID)+"/following", nil, nil)
+}
+
+// MarkThreadRead marks all replies of the thread with root post threadID as read.
+func (m *Client) MarkThreadRead(teamID, threadID string) error {
+	return m.apiRequest(http.MethodPut, m.threadRoute(teamID, threadID)+"/read/"+strconv.FormatInt(model.GetMillis(), 10), nil, nil)
+}
+
+// GetThreads returns the threads we follow in all our teams, only the ones
+// with unread replies when unread is set.
+func (m *Client) GetThreads(unread bool) ([]*Thread, error) {
+	var teamIDs []string
+
+	m.RLock()
+	for _, t := range m.OtherTeams {
+		teamIDs = append(teamIDs, t.ID)
+	}
+	m.RUnlock()
+
+	query := url.Values{}
+	query.Set("pageSize", "100")
+	query.Set("unread", strconv.FormatBool(unread))
+
+	var threads []*Thread
+
+	seen := make(map[string]bool)
+
+	for _, teamID := range teamIDs {
+		list := &threadList{}
+
+		err := m.apiRequest(http.MethodGet, m.Client.GetUserRoute("me")+"/teams/"+teamID+"/threads?"+query.Encode(), nil, list)
+		if err != nil {
+			return nil, err
+		}
+
+		// threads of direct messages are listed for every team
+		for _, thread := range list.Threads {
+			if seen[thread.ID] || thread.Post == nil {
+				continue
+			}
+
+			seen[thread.ID] = true
+
+			threads = append(threads, thread)
+		}
+	}
+
+	return threads, nil
+}
@@ -23,6 +23,10 @@ func (m *Client) GetStatus(userID string) string {
 		return "away"
 	}
 
+	if res.Status == model.STATUS_DND {
+		return "dnd"
+	}
+
 	if res.Status == model.STATUS_ONLINE {
 		return "online"
 	}
@@ -49,6 +53,10 @@ func (m *Client) GetStatuses() map[string]string {
 			statuses[status.UserId] = "away"
 		}
 
+		if status.Status == model.STATUS_DND {
+			statuses[status.UserId] = "dnd"
+		}
+
 		if status.Status == model.STATUS_ONLINE {
 			statuses[status.UserId] = "online"
 		}
@@ -61,6 +69,20 @@ func (m *Client) GetTeamID() string {
 	return m.Team.ID
 }
 
+// GetTeamIDByName returns the ID of our team with name.
+func (m *Client) GetTeamIDByName(name string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, t := range m.OtherTeams {
+		if t.Team.Name == name {
+			return t.ID
+		}
+	}
+
+	return ""
+}
+
 // GetTeamName returns the name of the specified teamId
 func (m *Client) GetTeamName(teamID string) string {
 	m.RLock()
@@ -92,6 +114,21 @@ func (m *Client) GetUser(userID string) *model.User {
 	return m.Users[userID]
 }
 
+// GetCachedUserByUsername returns the user with username if we know it,
+// without asking the server.
+func (m *Client) GetCachedUserByUsername(username string) *model.User {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, user := range m.Users {
+		if user.Username == username {
+			return user
+		}
+	}
+
+	return nil
+}
+
 func (m *Client) GetUserName(userID string) string {
 	user := m.GetUser(userID)
 	if user != nil {
@@ -0,0 +1,144 @@
+// Package secret resolves credential references to their actual values.
+//
+// A reference is a string starting with one of the following sources:
+//
+//	env:NAME         the value of environment variable NAME
+//	file:/some/path  the contents of /some/path
+//	cmd:command      the first line of the output of command (run with sh -c)
+//
+// Only sources that are explicitly allowed are resolved, everything else is
+// returned as is. This keeps existing passwords that happen to start with
+// one of the prefixes working.
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	SourceEnv  = "env"
+	SourceFile = "file"
+	SourceCmd  = "cmd"
+)
+
+// CommandTimeout is the maximum time a cmd: source is allowed to run.
+var CommandTimeout = 10 * time.Second
+
+type Resolver struct {
+	sources map[string]bool
+}
+
+// New returns a Resolver that only resolves the specified sources.
+func New(sources []string) *Resolver {
+	r := &Resolver{
+		sources: make(map[string]bool),
+	}
+
+	for _, source := range sources {
+		r.sources[strings.ToLower(source)] = true
+	}
+
+	return r
+}
+
+// Resolve returns the secret value referenced by value.
+// Values without an allowed source prefix are returned unchanged.
+func (r *Resolver) Resolve(value string) (string, error) {
+	source, ref, ok := split(value)
+	if !ok || r == nil || !r.sources[source] {
+		return value, nil
+	}
+
+	var (
+		secret string
+		err    error
+	)
+
+	switch source {
+	case SourceEnv:
+		var found bool
+		secret, found = os.LookupEnv(ref)
+		if !found {
+			err = fmt.Errorf("environment variable %s is not set", ref)
+		}
+	case SourceFile:
+		secret, err = readFile(ref)
+	case SourceCmd:
+		secret, err = runCommand(ref)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("resolving %s secret failed: %s", source, err)
+	}
+
+	if secret == "" {
+		return "", fmt.Errorf("resolving %s secret failed: empty value", source)
+	}
+
+	return secret, nil
+}
+
+// IsReference returns true if value starts with a known source prefix.
+func IsReference(value string) bool {
+	_, _, ok := split(value)
+
+	return ok
+}
+
+func split(value string) (string, string, bool) {
+	sp := strings.SplitN(value, ":", 2)
+	if len(sp) != 2 || sp[1] == "" {
+		return "", "", false
+	}
+
+	switch sp[0] {
+	case SourceEnv, SourceFile, SourceCmd:
+		return sp[0], sp[1], true
+	}
+
+	return "", "", false
+}
+
+func readFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func runCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		return "", err
+	}
+
+	// only use the first line, tools like pass store extra data on the next lines
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if scanner.Scan() {
+		return strings.TrimRight(scanner.Text(), "\r"), nil
+	}
+
+	return "", nil
+}
@@ -0,0 +1,65 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "mm")
+	assert.NoError(t, ioutil.WriteFile(secretFile, []byte("filesecret\n"), 0o600))
+
+	os.Setenv("MATTERIRCD_TEST_SECRET", "envsecret")
+	defer os.Unsetenv("MATTERIRCD_TEST_SECRET")
+
+	r := New([]string{"env", "file", "cmd"})
+
+	tests := []struct {
+		Desc   string
+		Value  string
+		Result string
+		IsGood bool
+	}{
+		{"plain value", "hunter2", "hunter2", true},
+		{"env source", "env:MATTERIRCD_TEST_SECRET", "envsecret", true},
+		{"missing env", "env:MATTERIRCD_TEST_DOES_NOT_EXIST", "", false},
+		{"file source", "file:" + secretFile, "filesecret", true},
+		{"missing file", "file:" + filepath.Join(dir, "nope"), "", false},
+		{"cmd source uses first line", "cmd:printf 'cmdsecret\\nlogin: foo\\n'", "cmdsecret", true},
+		{"failing cmd", "cmd:exit 1", "", false},
+		{"unknown prefix", "token:abc", "token:abc", true},
+	}
+
+	for _, tc := range tests {
+		result, err := r.Resolve(tc.Value)
+		if tc.IsGood {
+			assert.NoError(t, err, tc.Desc)
+		} else {
+			assert.Error(t, err, tc.Desc)
+		}
+		assert.Equal(t, tc.Result, result, tc.Desc)
+	}
+}
+
+func TestResolveNotAllowed(t *testing.T) {
+	r := New([]string{"env"})
+
+	result, err := r.Resolve("cmd:echo nope")
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:echo nope", result)
+
+	var nilResolver *Resolver
+
+	result, err = nilResolver.Resolve("env:HOME")
+	assert.NoError(t, err)
+	assert.Equal(t, "env:HOME", result)
+}